@@ -12,7 +12,8 @@ import (
 
 var DB *sql.DB
 
-func ConnectDB() {
+// ConnectionString builds the PostgreSQL connection string from the DB_* environment variables.
+func ConnectionString() string {
 	// Load environment variables from .env file (useful in local development)
 	_ = godotenv.Load()
 
@@ -22,10 +23,14 @@ func ConnectDB() {
 	password := os.Getenv("DB_PASSWORD")
 	dbname := os.Getenv("DB_NAME")
 
-	connStr := fmt.Sprintf(
+	return fmt.Sprintf(
 		"postgres://%s:%s@%s:%s/%s?sslmode=require",
 		user, password, host, port, dbname,
 	)
+}
+
+func ConnectDB() {
+	connStr := ConnectionString()
 
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
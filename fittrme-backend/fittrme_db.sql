@@ -0,0 +1,456 @@
+-- FittrMe PostgreSQL schema.
+-- Every statement is idempotent so the whole file can be re-run against an existing database.
+
+CREATE TABLE IF NOT EXISTS users (
+    user_id       SERIAL PRIMARY KEY,
+    username      TEXT NOT NULL UNIQUE,
+    email         TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS weights (
+    user_id        INT NOT NULL UNIQUE REFERENCES users(user_id) ON DELETE CASCADE,
+    current_weight NUMERIC(6, 2),
+    target_weight  NUMERIC(6, 2),
+    height         NUMERIC(6, 2),
+    dm_lstupddt    TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id         SERIAL PRIMARY KEY,
+    user_id    INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked    BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Refresh token rotation: every token issued from the same login shares a family_id,
+-- so presenting an already-rotated token can revoke the whole chain.
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS family_id TEXT;
+UPDATE refresh_tokens SET family_id = 'legacy-' || id WHERE family_id IS NULL;
+ALTER TABLE refresh_tokens ALTER COLUMN family_id SET NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+
+-- Per-device sessions. A session's id is the family_id shared by all of its refresh tokens.
+CREATE TABLE IF NOT EXISTS sessions (
+    id           TEXT PRIMARY KEY,
+    user_id      INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    device_name  TEXT NOT NULL DEFAULT '',
+    user_agent   TEXT NOT NULL DEFAULT '',
+    ip_address   TEXT NOT NULL DEFAULT '',
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    revoked_at   TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
+
+INSERT INTO sessions (id, user_id, device_name, created_at, last_used_at)
+SELECT family_id, MIN(user_id), 'Unknown device', MIN(created_at), MAX(created_at)
+FROM refresh_tokens
+GROUP BY family_id
+ON CONFLICT (id) DO NOTHING;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'refresh_tokens_family_id_fkey') THEN
+        ALTER TABLE refresh_tokens
+            ADD CONSTRAINT refresh_tokens_family_id_fkey
+            FOREIGN KEY (family_id) REFERENCES sessions(id) ON DELETE CASCADE;
+    END IF;
+END $$;
+
+-- Access token revocations checked by AuthRequired. Exactly one of jti, session_id or user_id is set;
+-- a user_id row revokes every token that user was issued up to revoked_at.
+-- Rows are only kept until the last token they can match has expired.
+CREATE TABLE IF NOT EXISTS token_revocations (
+    id         BIGSERIAL PRIMARY KEY,
+    jti        TEXT,
+    session_id TEXT,
+    user_id    INT REFERENCES users(user_id) ON DELETE CASCADE,
+    revoked_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    CHECK (num_nonnulls(jti, session_id, user_id) = 1)
+);
+CREATE INDEX IF NOT EXISTS idx_token_revocations_expires_at ON token_revocations (expires_at);
+
+-- Single-use password reset tokens; like refresh_tokens, only a hash of the token is stored.
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+    id         SERIAL PRIMARY KEY,
+    user_id    INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_password_reset_tokens_user_id ON password_reset_tokens (user_id);
+
+-- Email verification. Accounts that existed before verification was introduced are treated as verified.
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_name = 'users' AND column_name = 'email_verified_at'
+    ) THEN
+        ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMPTZ;
+        UPDATE users SET email_verified_at = created_at;
+    END IF;
+END $$;
+
+CREATE TABLE IF NOT EXISTS email_verification_tokens (
+    id         SERIAL PRIMARY KEY,
+    user_id    INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    email      TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_email_verification_tokens_user_id ON email_verification_tokens (user_id, created_at);
+
+-- TOTP two-factor authentication. The secret is encrypted with SECRETS_ENCRYPTION_KEY;
+-- last_used_step stops a code from being accepted twice.
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id          INT PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+    secret_encrypted TEXT NOT NULL,
+    confirmed_at     TIMESTAMPTZ,
+    last_used_step   BIGINT NOT NULL DEFAULT 0,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    id         SERIAL PRIMARY KEY,
+    user_id    INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    code_hash  TEXT NOT NULL,
+    used_at    TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_mfa_recovery_codes_user_id ON mfa_recovery_codes (user_id);
+
+-- Pending second login step, handed out by LoginUser to users with two-factor authentication.
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+    id          SERIAL PRIMARY KEY,
+    user_id     INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    token_hash  TEXT NOT NULL UNIQUE,
+    device_name TEXT NOT NULL DEFAULT '',
+    attempts    INT NOT NULL DEFAULT 0,
+    expires_at  TIMESTAMPTZ NOT NULL,
+    used_at     TIMESTAMPTZ,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Failed login counters, keyed by 'user:<user_id>', 'name:<unknown username>' or 'ip:<address>'.
+CREATE TABLE IF NOT EXISTS login_throttles (
+    throttle_key    TEXT PRIMARY KEY,
+    failures        INT NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_until    TIMESTAMPTZ
+);
+
+-- One row per account lockout, kept for support.
+CREATE TABLE IF NOT EXISTS account_lockouts (
+    id           SERIAL PRIMARY KEY,
+    user_id      INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    ip_address   TEXT NOT NULL DEFAULT '',
+    failures     INT NOT NULL,
+    locked_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMPTZ NOT NULL,
+    unlocked_at  TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_account_lockouts_user_id ON account_lockouts (user_id);
+
+-- Role-based access control. Roles are embedded in access tokens; every account is a member.
+-- Bootstrap the first admin with: INSERT INTO user_roles (user_id, role) VALUES (<user_id>, 'admin');
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id    INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    role       TEXT NOT NULL CHECK (role IN ('member', 'coach', 'admin')),
+    granted_by INT REFERENCES users(user_id) ON DELETE SET NULL,
+    granted_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, role)
+);
+INSERT INTO user_roles (user_id, role)
+SELECT user_id, 'member' FROM users
+ON CONFLICT (user_id, role) DO NOTHING;
+
+-- Admin actions: suspension blocks every login; lockouts record which admin lifted them.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended_at TIMESTAMPTZ;
+ALTER TABLE account_lockouts ADD COLUMN IF NOT EXISTS unlocked_by INT REFERENCES users(user_id) ON DELETE SET NULL;
+
+-- Personal access tokens for scripts and smart scales. Only the hash is stored;
+-- token_prefix keeps the first characters so users can tell tokens apart.
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id           SERIAL PRIMARY KEY,
+    user_id      INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    name         TEXT NOT NULL,
+    token_prefix TEXT NOT NULL,
+    token_hash   TEXT NOT NULL UNIQUE,
+    scopes       TEXT[] NOT NULL,
+    expires_at   TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    revoked_at   TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens (user_id);
+
+-- OpenID Connect login ("Sign in with Google/Apple"). Accounts created this way
+-- start with an empty password_hash, so they have no password until a reset.
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+    state_hash    TEXT PRIMARY KEY,
+    provider      TEXT NOT NULL,
+    nonce         TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    device_name   TEXT NOT NULL DEFAULT '',
+    expires_at    TIMESTAMPTZ NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS user_identities (
+    id            SERIAL PRIMARY KEY,
+    user_id       INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    provider      TEXT NOT NULL,
+    subject       TEXT NOT NULL,
+    email         TEXT NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_login_at TIMESTAMPTZ,
+    UNIQUE (provider, subject)
+);
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities (user_id);
+
+-- Passkeys (WebAuthn credentials). credential_id is the Base64URL credential ID,
+-- public_key the COSE_Key the authenticator returned at registration.
+CREATE TABLE IF NOT EXISTS passkeys (
+    id            SERIAL PRIMARY KEY,
+    user_id       INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    credential_id TEXT NOT NULL UNIQUE,
+    public_key    BYTEA NOT NULL,
+    sign_count    BIGINT NOT NULL DEFAULT 0,
+    transports    TEXT[] NOT NULL DEFAULT '{}',
+    aaguid        TEXT NOT NULL DEFAULT '',
+    name          TEXT NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at  TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_passkeys_user_id ON passkeys (user_id);
+
+-- Pending registration and login ceremonies; user_id is NULL for logins.
+CREATE TABLE IF NOT EXISTS webauthn_challenges (
+    id         TEXT PRIMARY KEY,
+    user_id    INT REFERENCES users(user_id) ON DELETE CASCADE,
+    ceremony   TEXT NOT NULL CHECK (ceremony IN ('registration', 'login')),
+    challenge  TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Profile fields, editable through PATCH /me.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS display_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS date_of_birth DATE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS sex TEXT CHECK (sex IN ('female', 'male', 'other'));
+ALTER TABLE users ADD COLUMN IF NOT EXISTS activity_level TEXT
+    CHECK (activity_level IN ('sedentary', 'light', 'moderate', 'active', 'very_active'));
+ALTER TABLE users ADD COLUMN IF NOT EXISTS timezone TEXT NOT NULL DEFAULT 'UTC';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locale TEXT NOT NULL DEFAULT 'en';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS units TEXT NOT NULL DEFAULT 'metric' CHECK (units IN ('metric', 'imperial'));
+
+-- Email changes: confirmed from the new address, undoable from the old one for a while.
+CREATE TABLE IF NOT EXISTS email_changes (
+    id                 SERIAL PRIMARY KEY,
+    user_id            INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    old_email          TEXT NOT NULL,
+    new_email          TEXT NOT NULL,
+    confirm_token_hash TEXT NOT NULL UNIQUE,
+    confirm_expires_at TIMESTAMPTZ NOT NULL,
+    confirmed_at       TIMESTAMPTZ,
+    cancelled_at       TIMESTAMPTZ, -- superseded by a newer request
+    undo_token_hash    TEXT UNIQUE,
+    undo_expires_at    TIMESTAMPTZ,
+    undone_at          TIMESTAMPTZ,
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_email_changes_user_id ON email_changes (user_id);
+
+-- Account deletion. DELETE /me sets delete_after; once it has passed, the purge job
+-- deletes the users row and everything that references it. Every table with user data
+-- must therefore reference users(user_id) ON DELETE CASCADE.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_requested_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS delete_after TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS idx_users_delete_after ON users (delete_after) WHERE delete_after IS NOT NULL;
+
+-- Tombstones of purged accounts, without personal data.
+CREATE TABLE IF NOT EXISTS deleted_accounts (
+    user_id               INT PRIMARY KEY,
+    deletion_requested_at TIMESTAMPTZ,
+    purged_at             TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Personal data exports. POST /me/exports queues a request, the export worker stores
+-- the zip archive here and download_token_hash guards the link until expires_at.
+CREATE TABLE IF NOT EXISTS data_exports (
+    id                  SERIAL PRIMARY KEY,
+    user_id             INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    status              TEXT NOT NULL DEFAULT 'pending'
+                        CHECK (status IN ('pending', 'running', 'ready', 'failed', 'expired')),
+    error               TEXT NOT NULL DEFAULT '',
+    download_token_hash TEXT NOT NULL UNIQUE,
+    archive             BYTEA,
+    size_bytes          BIGINT,
+    requested_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    started_at          TIMESTAMPTZ,
+    completed_at        TIMESTAMPTZ,
+    expires_at          TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_data_exports_user_id ON data_exports (user_id);
+CREATE INDEX IF NOT EXISTS idx_data_exports_pending ON data_exports (requested_at) WHERE status IN ('pending', 'running');
+
+-- Security audit log (signups, logins, token refreshes, password/email/MFA changes).
+-- Append-only: updates are rejected, and rows can only be deleted by the cascade
+-- when their account is purged. user_id is NULL for logins with an unknown username.
+CREATE TABLE IF NOT EXISTS security_events (
+    id         BIGSERIAL PRIMARY KEY,
+    user_id    INT REFERENCES users(user_id) ON DELETE CASCADE,
+    event_type TEXT NOT NULL,
+    ip_address TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    session_id TEXT,
+    details    JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_security_events_user_id ON security_events (user_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_security_events_ip_address ON security_events (ip_address, id DESC);
+CREATE INDEX IF NOT EXISTS idx_security_events_session_id ON security_events (session_id) WHERE session_id IS NOT NULL;
+
+CREATE OR REPLACE FUNCTION security_events_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' AND OLD.user_id IS NOT NULL
+        AND NOT EXISTS (SELECT 1 FROM users WHERE user_id = OLD.user_id) THEN
+        RETURN OLD;
+    END IF;
+    RAISE EXCEPTION 'security_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS security_events_append_only ON security_events;
+CREATE TRIGGER security_events_append_only
+    BEFORE UPDATE OR DELETE ON security_events
+    FOR EACH ROW EXECUTE FUNCTION security_events_append_only();
+
+-- New-device login alerts. Each login records salted, hashed fingerprints of the
+-- device and network; a login with an unseen one raises a login alert. Reporting an
+-- alert ("this wasn't me") sets password_reset_required_at until the password is reset.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_reset_required_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS login_fingerprints (
+    user_id          INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    kind             TEXT NOT NULL CHECK (kind IN ('device', 'network')),
+    fingerprint_hash TEXT NOT NULL,
+    first_seen_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_seen_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, kind, fingerprint_hash)
+);
+
+CREATE TABLE IF NOT EXISTS login_alerts (
+    id                SERIAL PRIMARY KEY,
+    user_id           INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    session_id        TEXT NOT NULL,
+    device_name       TEXT NOT NULL DEFAULT '',
+    ip_address        TEXT NOT NULL DEFAULT '',
+    user_agent        TEXT NOT NULL DEFAULT '',
+    new_device        BOOLEAN NOT NULL,
+    new_network       BOOLEAN NOT NULL,
+    report_token_hash TEXT NOT NULL UNIQUE,
+    report_expires_at TIMESTAMPTZ NOT NULL,
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    read_at           TIMESTAMPTZ,
+    reported_at       TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_login_alerts_user_id ON login_alerts (user_id, created_at DESC);
+
+-- Weight history. weights used to hold one upserted row per user; every weigh-in is
+-- now its own row with a client-supplied measured_at. Existing rows are kept, with
+-- their last update time as measured_at. dm_lstupddt stays the row's last change.
+ALTER TABLE weights ADD COLUMN IF NOT EXISTS id BIGSERIAL;
+ALTER TABLE weights ADD COLUMN IF NOT EXISTS measured_at TIMESTAMPTZ;
+UPDATE weights SET measured_at = COALESCE(dm_lstupddt, NOW()) WHERE measured_at IS NULL;
+ALTER TABLE weights ALTER COLUMN measured_at SET DEFAULT NOW();
+ALTER TABLE weights ALTER COLUMN measured_at SET NOT NULL;
+ALTER TABLE weights DROP CONSTRAINT IF EXISTS weights_user_id_key;
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = 'weights'::regclass AND contype = 'p') THEN
+        ALTER TABLE weights ADD PRIMARY KEY (id);
+    END IF;
+END $$;
+-- One weigh-in per instant: re-posting the same measured_at updates it (see SaveWeight)
+CREATE UNIQUE INDEX IF NOT EXISTS idx_weights_user_measured_at ON weights (user_id, measured_at);
+
+-- Editing weigh-ins. version increases with every change (optimistic concurrency, see
+-- handlers/weightedits.go). Deletes only set deleted_at; the weight cleanup job removes
+-- rows once the undo window has passed. Deleted rows do not block their measured_at.
+ALTER TABLE weights ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+ALTER TABLE weights ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+DROP INDEX IF EXISTS idx_weights_user_measured_at;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_weights_user_measured_at_live ON weights (user_id, measured_at) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_weights_deleted_at ON weights (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Body measurements. measurement_types is the registry of what can be measured and in
+-- which unit; admins can add types. body_measurements holds the readings and works
+-- like weights: client-supplied measured_at, version for optimistic concurrency and
+-- soft deletes removed by the weight cleanup job after the undo window.
+CREATE TABLE IF NOT EXISTS measurement_types (
+    key        TEXT PRIMARY KEY,
+    name       TEXT NOT NULL,
+    unit       TEXT NOT NULL CHECK (unit IN ('percent', 'kg', 'cm')),
+    min_value  NUMERIC(8,2) NOT NULL,
+    max_value  NUMERIC(8,2) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK (min_value < max_value)
+);
+INSERT INTO measurement_types (key, name, unit, min_value, max_value) VALUES
+    ('body_fat', 'Body fat', 'percent', 2, 75),
+    ('lean_mass', 'Lean mass', 'kg', 10, 200),
+    ('waist', 'Waist', 'cm', 30, 300),
+    ('hip', 'Hip', 'cm', 30, 300),
+    ('chest', 'Chest', 'cm', 30, 300),
+    ('arm', 'Upper arm', 'cm', 10, 100),
+    ('thigh', 'Thigh', 'cm', 20, 150)
+ON CONFLICT (key) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS body_measurements (
+    id          BIGSERIAL PRIMARY KEY,
+    user_id     INT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    type_key    TEXT NOT NULL REFERENCES measurement_types(key),
+    value       NUMERIC(8,2) NOT NULL,
+    measured_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    version     INT NOT NULL DEFAULT 1,
+    deleted_at  TIMESTAMPTZ,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_body_measurements_live ON body_measurements (user_id, type_key, measured_at) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_body_measurements_history ON body_measurements (user_id, measured_at DESC, id DESC) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_body_measurements_deleted_at ON body_measurements (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Login fingerprints are now keyed hashes (HMAC with LOGIN_FINGERPRINT_KEY). Unkeyed
+-- ones can never match again, so they are dropped once; the next login of each user
+-- records fresh fingerprints without raising an alert.
+ALTER TABLE login_fingerprints ADD COLUMN IF NOT EXISTS keyed BOOLEAN NOT NULL DEFAULT FALSE;
+DELETE FROM login_fingerprints WHERE NOT keyed;
+ALTER TABLE login_fingerprints ALTER COLUMN keyed SET DEFAULT TRUE;
+
+-- Usernames are unique regardless of case and log in case-insensitively. Names that only
+-- differ in case from an older account get their user ID appended so the index can be built.
+UPDATE users u SET username = u.username || '_' || u.user_id
+WHERE EXISTS (
+    SELECT 1 FROM users o WHERE LOWER(o.username) = LOWER(u.username) AND o.user_id < u.user_id
+);
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_lower_key ON users (LOWER(username));
+
+-- One export in progress per user. Duplicates queued before the index existed are
+-- failed, keeping each user's oldest.
+UPDATE data_exports e SET status = 'failed', error = 'Another export was already being prepared', completed_at = NOW()
+WHERE e.status IN ('pending', 'running') AND EXISTS (
+    SELECT 1 FROM data_exports o
+    WHERE o.user_id = e.user_id AND o.status IN ('pending', 'running') AND o.id < e.id
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_data_exports_in_progress ON data_exports (user_id) WHERE status IN ('pending', 'running');
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"fittrme-backend/database"
+	"fittrme-backend/models"
+	"fittrme-backend/revocation"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// userIDParam reads the :id path parameter of the /admin/users routes and checks the user exists.
+// It writes the error response itself and returns false if the request should stop.
+func userIDParam(c *gin.Context) (int, bool) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+		return 0, false
+	}
+
+	var exists bool
+	if err := database.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE user_id = $1)`, userID).Scan(&exists); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return 0, false
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return 0, false
+	}
+	return userID, true
+}
+
+// ListUserRoles returns the roles of a user.
+func ListUserRoles(c *gin.Context) {
+	userID, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+
+	roles, err := userRoles(database.DB, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"userId": userID, "roles": roles})
+}
+
+// GrantRole gives a user a role. The user's current access tokens are revoked so the
+// next refresh picks up the new roles claim.
+func GrantRole(c *gin.Context) {
+	adminID, _ := extractUserID(c)
+	userID, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+
+	var input models.RoleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, err := database.DB.Exec(`
+	INSERT INTO user_roles (user_id, role, granted_by)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (user_id, role) DO NOTHING
+`, userID, input.Role, adminID)
+	if err == nil {
+		err = revocation.RevokeUser(database.DB, userID)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to grant role"})
+		return
+	}
+
+	roles, err := userRoles(database.DB, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Role granted", "userId": userID, "roles": roles})
+}
+
+// RevokeRole takes a role away from a user, effective immediately.
+func RevokeRole(c *gin.Context) {
+	adminID, _ := extractUserID(c)
+	userID, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+	role := c.Param("role")
+
+	// Stop admins from locking themselves (and possibly everyone) out of the admin API
+	if userID == adminID && role == models.RoleAdmin {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot revoke your own admin role"})
+		return
+	}
+
+	res, err := database.DB.Exec(`DELETE FROM user_roles WHERE user_id = $1 AND role = $2`, userID, role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke role"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User does not have this role"})
+		return
+	}
+	if err := revocation.RevokeUser(database.DB, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke role"})
+		return
+	}
+
+	roles, err := userRoles(database.DB, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Role revoked", "userId": userID, "roles": roles})
+}
+
+// SuspendUser blocks an account: every session and access token is revoked at once
+// and the user cannot sign in again until UnsuspendUser.
+func SuspendUser(c *gin.Context) {
+	adminID, _ := extractUserID(c)
+	userID, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+	if userID == adminID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot suspend your own account"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE users SET suspended_at = COALESCE(suspended_at, NOW()) WHERE user_id = $1`, userID)
+	if err == nil {
+		err = revokeOtherSessions(tx, userID, "")
+	}
+	if err == nil {
+		err = revocation.RevokeUser(tx, userID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to suspend user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User suspended"})
+}
+
+// UnsuspendUser lets a suspended account sign in again.
+func UnsuspendUser(c *gin.Context) {
+	userID, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+
+	if _, err := database.DB.Exec(`UPDATE users SET suspended_at = NULL WHERE user_id = $1`, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unsuspend user"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "User unsuspended"})
+}
+
+// UnlockUser lifts a login lockout early (see recordLoginFailure) and marks the
+// open lockout records as handled by the calling admin.
+func UnlockUser(c *gin.Context) {
+	adminID, _ := extractUserID(c)
+	userID, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM login_throttles WHERE throttle_key = $1`, accountThrottleKey(userID, ""))
+	if err == nil {
+		_, err = tx.Exec(`
+	UPDATE account_lockouts SET unlocked_at = NOW(), unlocked_by = $2
+	WHERE user_id = $1 AND unlocked_at IS NULL AND locked_until > NOW()
+`, userID, adminID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked"})
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"fittrme-backend/database"
+	"fittrme-backend/middleware"
+	"fittrme-backend/models"
+	"fittrme-backend/utils"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// apiTokenPrefixLength is how much of a token is kept in clear so users can tell their tokens apart.
+const apiTokenPrefixLength = 12
+
+// CreateAPIToken creates a personal access token for scripts and devices.
+// The raw token is in this response only; afterwards just its prefix is shown.
+func CreateAPIToken(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+
+	var input models.CreateAPITokenInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rawToken, _, err := utils.NewOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	rawToken = middleware.APITokenPrefix + rawToken
+
+	// Store each scope once, in a stable order
+	scopes := map[string]bool{}
+	for _, s := range input.Scopes {
+		scopes[s] = true
+	}
+	token := models.APIToken{Name: input.Name, Prefix: rawToken[:apiTokenPrefixLength]}
+	for s := range scopes {
+		token.Scopes = append(token.Scopes, s)
+	}
+	sort.Strings(token.Scopes)
+	if input.ExpiresInDays > 0 {
+		expiresAt := time.Now().Add(time.Hour * 24 * time.Duration(input.ExpiresInDays))
+		token.ExpiresAt = &expiresAt
+	}
+
+	err = database.DB.QueryRow(`
+	INSERT INTO api_tokens (user_id, name, token_prefix, token_hash, scopes, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at
+`, userId, token.Name, token.Prefix, utils.HashToken(rawToken), pq.Array(token.Scopes), token.ExpiresAt).Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "Copy this token now, it won't be shown again",
+		"token":    rawToken,
+		"apiToken": token,
+	})
+}
+
+// ListAPITokens returns the logged-in user's active personal access tokens.
+func ListAPITokens(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+
+	rows, err := database.DB.Query(`
+	SELECT id, name, token_prefix, scopes, expires_at, last_used_at, created_at
+	FROM api_tokens
+	WHERE user_id = $1 AND revoked_at IS NULL
+	ORDER BY created_at DESC
+`, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	tokens := []models.APIToken{}
+	for rows.Next() {
+		var t models.APIToken
+		if err := rows.Scan(&t.ID, &t.Name, &t.Prefix, pq.Array(&t.Scopes), &t.ExpiresAt, &t.LastUsedAt, &t.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"apiTokens": tokens})
+}
+
+// RevokeAPIToken revokes one of the logged-in user's personal access tokens, effective immediately.
+func RevokeAPIToken(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+	tokenID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token id"})
+		return
+	}
+
+	res, err := database.DB.Exec(`
+	UPDATE api_tokens SET revoked_at = NOW()
+	WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+`, tokenID, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked"})
+}
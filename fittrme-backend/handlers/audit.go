@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fittrme-backend/database"
+	"fittrme-backend/models"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The security audit log is append-only: the database rejects updates and deletes of
+// security_events (see fittrme_db.sql), except for the cascade when an account is purged.
+
+const (
+	securityEventsDefaultLimit = 50
+	securityEventsMaxLimit     = 200
+	// auditUserAgentMaxLength keeps an oversized User-Agent header from bloating the log.
+	auditUserAgentMaxLength = 512
+)
+
+// recordSecurityEvent appends an event to the audit log, with the client's IP address
+// and user agent. userID is 0 when the account is unknown (e.g. a login with a wrong
+// username); sessionID defaults to the session of the request's access token.
+// Recording is best effort: a failure is logged and never fails the request.
+func recordSecurityEvent(c *gin.Context, userID int, eventType, sessionID string, details gin.H) {
+	if sessionID == "" {
+		sessionID = extractSessionID(c)
+	}
+	if details == nil {
+		details = gin.H{}
+	}
+	detailsJSON, err := json.Marshal(details)
+	if err == nil {
+		userAgent := c.Request.UserAgent()
+		if len(userAgent) > auditUserAgentMaxLength {
+			userAgent = userAgent[:auditUserAgentMaxLength]
+		}
+		_, err = database.DB.Exec(`
+	INSERT INTO security_events (user_id, event_type, ip_address, user_agent, session_id, details)
+	VALUES (NULLIF($1, 0), $2, $3, $4, NULLIF($5, ''), $6)
+`, userID, eventType, c.ClientIP(), userAgent, sessionID, string(detailsJSON))
+	}
+	if err != nil {
+		log.Printf("Failed to record security event %s: %v", eventType, err)
+	}
+}
+
+// listSecurityEvents responds with a page of audit log entries matching the query
+// parameters type (comma separated), from, to (RFC 3339) and the cursor before,
+// plus the conditions already in q.
+func listSecurityEvents(c *gin.Context, q historyQuery) {
+	limit, ok := pageLimit(c, securityEventsDefaultLimit, securityEventsMaxLimit)
+	if !ok {
+		return
+	}
+	if s := c.Query("before"); s != "" {
+		before, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		q.and("id < " + q.arg(before))
+	}
+	if s := c.Query("type"); s != "" {
+		q.and("event_type = ANY(string_to_array(" + q.arg(s) + ", ','))")
+	}
+	for param, op := range map[string]string{"from": ">=", "to": "<"} {
+		s := c.Query(param)
+		if s == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param + " time, expected RFC 3339"})
+			return
+		}
+		q.and("created_at " + op + " " + q.arg(t))
+	}
+	query := q.build(`SELECT id, user_id, event_type, ip_address, user_agent, session_id, details, created_at FROM security_events`,
+		"id DESC", limit+1)
+
+	rows, err := database.DB.Query(query, q.args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	events := []models.SecurityEvent{}
+	for rows.Next() {
+		var e models.SecurityEvent
+		var userID sql.NullInt64
+		var sessionID sql.NullString
+		if err := rows.Scan(&e.ID, &userID, &e.Type, &e.IPAddress, &e.UserAgent, &sessionID, &e.Details, &e.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if userID.Valid {
+			id := int(userID.Int64)
+			e.UserID = &id
+		}
+		if sessionID.Valid {
+			e.SessionID = &sessionID.String
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// One extra row tells whether there is another page
+	var nextCursor *int64
+	if len(events) > limit {
+		events = events[:limit]
+		nextCursor = &events[limit-1].ID
+	}
+	c.JSON(http.StatusOK, gin.H{"events": events, "nextCursor": nextCursor})
+}
+
+// ListMySecurityEvents returns the logged-in user's audit log, newest first.
+func ListMySecurityEvents(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+	var q historyQuery
+	q.and("user_id = " + q.arg(userId))
+	listSecurityEvents(c, q)
+}
+
+// ListSecurityEvents returns audit log entries across users, newest first. Besides the
+// filters of ListMySecurityEvents, admins can filter by userId, ip and sessionId.
+func ListSecurityEvents(c *gin.Context) {
+	var q historyQuery
+	if s := c.Query("userId"); s != "" {
+		userID, err := strconv.Atoi(s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+		q.and("user_id = " + q.arg(userID))
+	}
+	if s := c.Query("ip"); s != "" {
+		q.and("ip_address = " + q.arg(s))
+	}
+	if s := c.Query("sessionId"); s != "" {
+		q.and("session_id = " + q.arg(s))
+	}
+	listSecurityEvents(c, q)
+}
@@ -6,11 +6,13 @@ import (
 	"fittrme-backend/database"
 	"fittrme-backend/models"
 	"fittrme-backend/utils"
+	"log"
 	"net/http"
 	"strings"
+	"sync"
 
 	"github.com/gin-gonic/gin"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/lib/pq"
 )
 
 func SignupUser(c *gin.Context) {
@@ -22,7 +24,12 @@ func SignupUser(c *gin.Context) {
 	}
 
 	//trim the email address(space before and after ) and then changing to lowercase because mails are case insensitive
-	email := strings.ToLower(strings.TrimSpace(input.Email))
+	email := normalizeEmail(input.Email)
+
+	username, ok := normalizeUsername(c, input.Username)
+	if !ok {
+		return
+	}
 
 	//check where email already exists
 	var exists bool
@@ -35,32 +42,51 @@ func SignupUser(c *gin.Context) {
 		c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
 		return
 	}
+	exists, err = usernameTaken(database.DB, username, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if exists {
+		c.JSON(http.StatusConflict, gin.H{"error": "Username is already taken"})
+		return
+	}
+
+	// Enforce the password policy before anything is stored
+	if rejectWeakPassword(c, "password", input.Password, username, email) {
+		return
+	}
 
-	// 4) bcrypt hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	// 4) hash password (Argon2id, see utils.HashPassword)
+	hashedPassword, err := utils.HashPassword(input.Password)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
 		return
 	}
 
-	// 5️⃣ Insert user into DB
-	var newUser models.User
-	err = database.DB.QueryRow(`
-	INSERT INTO users (username, email, password_hash)
-	VALUES ($1, $2, $3)
-	RETURNING user_id, username, email, password_hash, created_at
-`, input.Username, email, string(hashedPassword)).Scan(
-		&newUser.UserID,
-		&newUser.Username,
-		&newUser.Email,
-		&newUser.PasswordHash,
-		&newUser.CreatedAt,
-	)
-	if err != nil {
+	// 5️⃣ Insert user into DB, together with the default member role
+	newUser, err := createUser(database.DB, username, email, hashedPassword, false)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		// Lost a race with another signup for the same username or email
+		if strings.Contains(pqErr.Constraint, "username") {
+			c.JSON(http.StatusConflict, gin.H{"error": "Username is already taken"})
+		} else {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+		}
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating user"})
 		return
 	}
 
+	// 6) Email a verification link. The account already exists, so a delivery
+	// failure is only logged; the user can ask for a new link later.
+	if err := sendVerificationEmail(newUser.UserID, newUser.Email); err != nil {
+		log.Println("Failed to send verification email:", err)
+	}
+	recordSecurityEvent(c, newUser.UserID, models.EventSignup, "", nil)
+
 	//return response
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "user registered successfully",
@@ -69,95 +95,212 @@ func SignupUser(c *gin.Context) {
 
 }
 
+// dummyPasswordHash is compared against when the username does not exist,
+// so LoginUser takes as long for unknown usernames as for wrong passwords.
+// It is built on first use so it picks up the Argon2 parameters from .env.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, err := utils.HashPassword("fittrme-dummy-password")
+	if err != nil {
+		log.Println("Failed to build dummy password hash:", err)
+	}
+	return hash
+})
+
 func LoginUser(c *gin.Context) {
 	var input models.LoginInput
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	var user models.User
-	row := database.DB.QueryRow(`select user_id, username, email, password_hash, created_at from users where username=$1`, input.Username)
-	err := row.Scan(&user.UserID, &user.Username, &user.Email, &user.PasswordHash, &user.CreatedAt)
-	if errors.Is(err, sql.ErrNoRows) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Username doesn't exist"})
-		return
-	} else if err != nil {
+	input.Username = strings.TrimSpace(input.Username)
+	user, err := scanUser(database.DB.QueryRow(`select `+userColumns+` from users where LOWER(username) = LOWER($1)`, input.Username))
+	found := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
 
-	//compare password
-	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(input.Password))
+	// Refuse the attempt while the account or this IP is backing off after failed attempts
+	accountKey := accountThrottleKey(user.UserID, input.Username)
+	wait, err := loginRetryAfter(accountKey, c.ClientIP())
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if wait > 0 {
+		recordSecurityEvent(c, user.UserID, models.EventLoginFailed, "", loginFailureDetails("password", "throttled", found, input.Username))
+		respondTooManyAttempts(c, wait)
 		return
 	}
 
-	// Generate a short-lived access token (JWT) for this user.
-	// If token signing fails, return 500 with a generic error.
-	accessToken, err := utils.GenerateAccessToken(user.UserID)
+	//compare password; unknown usernames (and accounts without a password) are checked
+	//against a dummy hash so every failure takes the same time and gets the same error
+	hasPassword := found && user.PasswordHash != ""
+	passwordHash := user.PasswordHash
+	if !hasPassword {
+		passwordHash = dummyPasswordHash()
+	}
+	matched, needsRehash, err := utils.VerifyPassword(passwordHash, input.Password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate access token"})
+		log.Println("Failed to verify password:", err)
+	}
+	if !matched || !hasPassword {
+		if err := recordLoginFailure(accountKey, user.UserID, c.ClientIP()); err != nil {
+			log.Println("Failed to record login failure:", err)
+		}
+		recordSecurityEvent(c, user.UserID, models.EventLoginFailed, "", loginFailureDetails("password", "invalid_credentials", found, input.Username))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": invalidCredentialsError})
+		return
+	}
+
+	// After a reported login the password is known to someone else; only a reset
+	// (which proves access to the email address) lifts the block
+	if user.PasswordResetRequiredAt != nil {
+		recordSecurityEvent(c, user.UserID, models.EventLoginFailed, "", gin.H{"method": "password", "reason": "password_reset_required"})
+		c.JSON(http.StatusForbidden, gin.H{"error": "Please reset your password before logging in"})
 		return
 	}
 
-	// Create a long-lived refresh token:
-	// - generate a random raw token for the client
-	// - compute its SHA-256 hash for storage in the database
-	// - compute its expiry timestamp based on env config
-	// If generation fails, return 500.
-	rawRefreshToken, refreshTokenHash, refreshTokenExp, err := utils.NewRefreshToken()
+	// Upgrade a bcrypt hash, or an Argon2id hash with outdated parameters, now that
+	// we have the plaintext. Failing to do so is not a reason to refuse the login.
+	if needsRehash {
+		if err := rehashPassword(user, input.Password); err != nil {
+			log.Println("Failed to upgrade password hash:", err)
+		}
+	}
+
+	// Under the block policy, unverified accounts cannot log in at all
+	if user.EmailVerifiedAt == nil && emailVerificationPolicy() == VerificationPolicyBlock {
+		recordSecurityEvent(c, user.UserID, models.EventLoginFailed, "", gin.H{"method": "password", "reason": "email_not_verified"})
+		c.JSON(http.StatusForbidden, gin.H{"error": "Please verify your email address before logging in"})
+		return
+	}
+
+	// Accounts with two-factor authentication get a short-lived MFA challenge
+	// instead of tokens; /login/mfa completes the login with a valid code.
+	enrolled, err := mfaEnrolled(user.UserID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate refresh token"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if enrolled {
+		startMFAChallenge(c, user.UserID, input.DeviceName)
 		return
 	}
 
-	// Persist the hashed refresh token in the database so we can validate future refresh requests.
-	// (We only store the hash, never the raw token.)
-	// If the insert fails, return 500.
+	completeLogin(c, user, input.DeviceName, "password")
+}
+
+// loginFailureDetails describes a failed login for the audit log. The attempted
+// username is only kept when it matches no account, since the event has no user then.
+func loginFailureDetails(method, reason string, userFound bool, username string) gin.H {
+	details := gin.H{"method": method, "reason": reason}
+	if !userFound {
+		details["username"] = username
+	}
+	return details
+}
+
+// rehashPassword replaces a user's password hash with one from utils.HashPassword.
+// The update is skipped if the password was changed concurrently.
+func rehashPassword(user models.User, password string) error {
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
 	_, err = database.DB.Exec(`
-	INSERT INTO refresh_tokens (user_id, token_hash, expires_at)
-	VALUES ($1, $2, $3)
-`, user.UserID, refreshTokenHash, refreshTokenExp)
+	UPDATE users SET password_hash = $1 WHERE user_id = $2 AND password_hash = $3
+`, hash, user.UserID, user.PasswordHash)
+	return err
+}
+
+// completeLogin starts a session for an authenticated user and responds with
+// the access/refresh token pair. Every login method ends here; method names it
+// in the audit log ("password", "passkey", "oidc:<provider>", "mfa:<code type>").
+func completeLogin(c *gin.Context, user models.User, deviceName, method string) {
+	// Suspended accounts cannot sign in by any method
+	if user.SuspendedAt != nil {
+		recordSecurityEvent(c, user.UserID, models.EventLoginFailed, "", gin.H{"method": method, "reason": "suspended"})
+		c.JSON(http.StatusForbidden, gin.H{"error": "This account has been suspended"})
+		return
+	}
+
+	if err := clearLoginFailures(user.UserID); err != nil {
+		log.Println("Failed to clear login failures:", err)
+	}
+
+	// Logging in during the grace period cancels a pending account deletion
+	deletionCancelled := false
+	if user.DeleteAfter != nil {
+		_, err := database.DB.Exec(`
+	UPDATE users SET deletion_requested_at = NULL, delete_after = NULL WHERE user_id = $1
+`, user.UserID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel account deletion"})
+			return
+		}
+		deletionCancelled = true
+	}
+
+	// Start a new session for this device; every refresh token rotated
+	// out of it via /refresh stays in the same session.
+	sessionID, err := createSession(database.DB, c, user.UserID, deviceName)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store refresh token"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
 		return
 	}
 
+	// Issue the access token (JWT) and persist the hashed refresh token.
+	// If either step fails, return 500 with a generic error.
+	tokens, err := issueTokenPair(database.DB, user.UserID, sessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate tokens"})
+		return
+	}
+	recordSecurityEvent(c, user.UserID, models.EventLoginSucceeded, sessionID, gin.H{
+		"method":            method,
+		"deviceName":        deviceName,
+		"deletionCancelled": deletionCancelled,
+	})
+	checkNewDeviceLogin(c, user, sessionID, deviceName)
+
 	// Build the login response:
 	// - include the access token (JWT)
 	// - include the raw refresh token (client must store this securely)
 	// - include minimal user info needed by the client (id/username/email)
 	// Return 200 OK with the JSON payload.
 	c.JSON(http.StatusOK, gin.H{
-		"message":      "Login successful",
-		"accessToken":  accessToken,
-		"refreshToken": rawRefreshToken,
+		"message":           "Login successful",
+		"accessToken":       tokens.AccessToken,
+		"refreshToken":      tokens.RefreshToken,
+		"sessionId":         sessionID,
+		"deletionCancelled": deletionCancelled,
 		"user": gin.H{
-			"userId":   user.UserID,
-			"username": user.Username,
-			"email":    user.Email,
+			"userId":        user.UserID,
+			"username":      user.Username,
+			"email":         user.Email,
+			"emailVerified": user.EmailVerifiedAt != nil,
 		},
 	})
-
 }
 
-// LogoutUser invalidates all refresh tokens for a logged-in user.
-// This ensures that they must log in again to get new tokens.
+// LogoutUser signs out the session (device) the access token was issued for.
+// Other devices of the same user stay signed in; see RevokeOtherSessions.
 func LogoutUser(c *gin.Context) {
-	// Step 1: Extract userId from JWT context
-	userId, exists := c.Get("userId")
-	if !exists {
+	// Step 1: Extract the session ID from JWT context
+	sessionID := extractSessionID(c)
+	if sessionID == "" {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 
-	// Step 2: Delete all refresh tokens for this user
-	_, err := database.DB.Exec(`DELETE FROM refresh_tokens WHERE user_id = $1`, userId.(int))
-	if err != nil {
+	// Step 2: Revoke that session, its refresh tokens and its access tokens
+	if err := revokeSession(database.DB, sessionID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout user"})
 		return
 	}
+	userId, _ := extractUserID(c)
+	recordSecurityEvent(c, userId, models.EventLogout, sessionID, nil)
 
 	// Step 3: Return success message
 	c.JSON(http.StatusOK, gin.H{
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"database/sql"
+	"fittrme-backend/database"
+	"net/url"
+	"os"
+	"testing"
+
+	_ "github.com/lib/pq"
+)
+
+// useTestDB points database.DB at a fresh copy of the schema in TEST_DATABASE_URL, a
+// PostgreSQL database the tests may write to. Tests that need it are skipped without one.
+func useTestDB(t *testing.T) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	schema, err := os.ReadFile("../fittrme_db.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	if _, err := admin.Exec(`DROP SCHEMA IF EXISTS fittrme_test CASCADE; CREATE SCHEMA fittrme_test`); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	q.Set("search_path", "fittrme_test")
+	u.RawQuery = q.Encode()
+	db, err := sql.Open("postgres", u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatalf("loading schema: %v", err)
+	}
+
+	previous := database.DB
+	database.DB = db
+	t.Cleanup(func() {
+		database.DB = previous
+		db.Close()
+	})
+}
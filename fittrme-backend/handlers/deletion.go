@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"errors"
+	"fittrme-backend/database"
+	"fittrme-backend/mailer"
+	"fittrme-backend/models"
+	"fittrme-backend/revocation"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// accountDeletionGracePeriod reads how long a deleted account can still be recovered
+// by logging in from ACCOUNT_DELETION_GRACE_DAYS (default 30 days).
+func accountDeletionGracePeriod() time.Duration {
+	days, _ := strconv.Atoi(os.Getenv("ACCOUNT_DELETION_GRACE_DAYS"))
+	if days == 0 {
+		days = 30
+	}
+	return time.Hour * 24 * time.Duration(days)
+}
+
+// DeleteAccount schedules the logged-in user's account for deletion. Every session and
+// personal access token is revoked now; logging in again before the grace period ends
+// cancels the deletion, otherwise jobs.StartAccountPurge deletes all of the user's data.
+func DeleteAccount(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+
+	var input models.DeleteAccountInput
+	if err := c.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := findUserByID(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Step 1: Re-authenticate
+	if !reauthenticate(c, user, input.Password, "Invalid password") {
+		return
+	}
+
+	// Step 2: Schedule the deletion and sign out everywhere
+	deleteAfter := time.Now().Add(accountDeletionGracePeriod())
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	UPDATE users SET deletion_requested_at = NOW(), delete_after = $1 WHERE user_id = $2
+`, deleteAfter, userId)
+	if err == nil {
+		_, err = tx.Exec(`UPDATE api_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, userId)
+	}
+	if err == nil {
+		err = revokeOtherSessions(tx, userId, "")
+	}
+	if err == nil {
+		err = revocation.RevokeUser(tx, userId)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+
+	// Step 3: Tell the user how to change their mind
+	if err := mailer.Default.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Your FittrMe account will be deleted",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Your FittrMe account and all of its data will be permanently deleted on %s.\n"+
+			"Changed your mind? Just log in before then and the deletion is cancelled.",
+			user.Username, deleteAfter.UTC().Format("2 January 2006")),
+	}); err != nil {
+		log.Println("Failed to send account deletion notice:", err)
+	}
+
+	recordSecurityEvent(c, userId, models.EventAccountDeletion, "", gin.H{"deleteAfter": deleteAfter})
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Account scheduled for deletion. Log in again before the date below to cancel.",
+		"deleteAfter": deleteAfter,
+	})
+}
@@ -0,0 +1,387 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fittrme-backend/database"
+	"fittrme-backend/mailer"
+	"fittrme-backend/models"
+	"fittrme-backend/utils"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Changing the email address moves the account's recovery channel, so it takes three steps:
+//  1. RequestEmailChange mails a confirmation link to the new address and a notice to the old one.
+//  2. ConfirmEmailChange applies the change and mails the old address an undo link.
+//  3. UndoEmailChange (within emailChangeUndoWindow) restores the old address and signs out everywhere.
+
+// emailChangeTTL reads the confirmation link lifetime from EMAIL_CHANGE_TTL_HOURS (default 24 hours).
+func emailChangeTTL() time.Duration {
+	hours, _ := strconv.Atoi(os.Getenv("EMAIL_CHANGE_TTL_HOURS"))
+	if hours == 0 {
+		hours = 24
+	}
+	return time.Hour * time.Duration(hours)
+}
+
+// emailChangeUndoWindow reads how long the old address can undo a change from EMAIL_CHANGE_UNDO_DAYS (default 7 days).
+func emailChangeUndoWindow() time.Duration {
+	days, _ := strconv.Atoi(os.Getenv("EMAIL_CHANGE_UNDO_DAYS"))
+	if days == 0 {
+		days = 7
+	}
+	return time.Hour * 24 * time.Duration(days)
+}
+
+// emailTaken reports whether another account already uses email.
+func emailTaken(db dbExecutor, email string, userID int) (bool, error) {
+	var taken bool
+	err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE email = $1 AND user_id <> $2)`, email, userID).Scan(&taken)
+	return taken, err
+}
+
+// RequestEmailChange starts changing the logged-in user's email address.
+func RequestEmailChange(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+
+	var input models.ChangeEmailInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	newEmail := normalizeEmail(input.NewEmail)
+
+	user, err := findUserByID(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Step 1: Re-authenticate, so a stolen access token alone cannot move the account
+	if !reauthenticate(c, user, input.Password, "Invalid password") {
+		return
+	}
+
+	// Step 2: Check the new address
+	if newEmail == user.Email {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This is already your email address"})
+		return
+	}
+	taken, err := emailTaken(database.DB, newEmail, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+		return
+	}
+
+	// Step 3: Replace any pending change with this one
+	rawToken, tokenHash, err := utils.NewOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	ttl := emailChangeTTL()
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	UPDATE email_changes SET cancelled_at = NOW()
+	WHERE user_id = $1 AND confirmed_at IS NULL AND cancelled_at IS NULL
+`, userId)
+	if err == nil {
+		_, err = tx.Exec(`
+	INSERT INTO email_changes (user_id, old_email, new_email, confirm_token_hash, confirm_expires_at)
+	VALUES ($1, $2, $3, $4, $5)
+`, userId, user.Email, newEmail, tokenHash, time.Now().Add(ttl))
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request email change"})
+		return
+	}
+
+	// Step 4: Confirmation link to the new address, heads-up to the old one
+	err = mailer.Default.Send(mailer.Message{
+		To:      newEmail,
+		Subject: "Confirm your new FittrMe email address",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Please confirm that you want to use this address for your FittrMe account by opening this link within %d hours:\n%s",
+			user.Username, int(ttl.Hours()), appLink("EMAIL_CHANGE_CONFIRM_URL", "http://localhost:8080/fittrme-api/me/email/confirm", rawToken)),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send confirmation email"})
+		return
+	}
+	if err := mailer.Default.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Your FittrMe email address is about to change",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Someone asked to change the email address of your FittrMe account to %s.\n"+
+			"Nothing changes until the new address is confirmed, and you will get a link to undo it.\n"+
+			"If this wasn't you, change your password now.",
+			user.Username, newEmail),
+	}); err != nil {
+		log.Println("Failed to send email change notice:", err)
+	}
+
+	recordSecurityEvent(c, userId, models.EventEmailChangeRequested, "", gin.H{"oldEmail": user.Email, "newEmail": newEmail})
+	c.JSON(http.StatusOK, gin.H{"message": "Check your new inbox to confirm the change"})
+}
+
+// ShowEmailChangeConfirmation is the link sent to the new address. It checks the link and
+// shows a confirmation form that posts to ConfirmEmailChange.
+func ShowEmailChangeConfirmation(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing token"})
+		return
+	}
+
+	var newEmail string
+	err := database.DB.QueryRow(`
+	SELECT ec.new_email
+	FROM email_changes ec
+	JOIN users u ON u.user_id = ec.user_id
+	WHERE ec.confirm_token_hash = $1 AND ec.confirmed_at IS NULL AND ec.cancelled_at IS NULL
+		AND ec.confirm_expires_at > NOW() AND u.email = ec.old_email
+`, utils.HashToken(token)).Scan(&newEmail)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired confirmation link"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	renderLinkConfirmation(c, linkConfirmation{
+		Title:   "Confirm your new email address",
+		Heading: "Confirm your new email address",
+		Text:    "Your FittrMe account will use " + newEmail + " from now on.",
+		Button:  "Confirm",
+		Token:   token,
+	})
+}
+
+// ConfirmEmailChange applies a pending email change when the form from
+// ShowEmailChangeConfirmation is submitted.
+func ConfirmEmailChange(c *gin.Context) {
+	token := c.PostForm("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing token"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Step 1: Find the pending change, locking it against concurrent use
+	var changeID, userID int
+	var oldEmail, newEmail, username string
+	err = tx.QueryRow(`
+	SELECT ec.id, ec.user_id, ec.old_email, ec.new_email, u.username
+	FROM email_changes ec
+	JOIN users u ON u.user_id = ec.user_id
+	WHERE ec.confirm_token_hash = $1 AND ec.confirmed_at IS NULL AND ec.cancelled_at IS NULL
+		AND ec.confirm_expires_at > NOW() AND u.email = ec.old_email
+	FOR UPDATE OF ec, u
+`, utils.HashToken(token)).Scan(&changeID, &userID, &oldEmail, &newEmail, &username)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired confirmation link"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Step 2: Someone may have registered the address since the change was requested
+	taken, err := emailTaken(tx, newEmail, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+		return
+	}
+
+	rawUndoToken, undoTokenHash, err := utils.NewOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	undoWindow := emailChangeUndoWindow()
+
+	// Step 3: Switch the address. Reset links went to the old address, so they stop working.
+	_, err = tx.Exec(`UPDATE users SET email = $1, email_verified_at = NOW() WHERE user_id = $2`, newEmail, userID)
+	if err == nil {
+		_, err = tx.Exec(`
+	UPDATE email_changes SET confirmed_at = NOW(), undo_token_hash = $1, undo_expires_at = $2
+	WHERE id = $3
+`, undoTokenHash, time.Now().Add(undoWindow), changeID)
+	}
+	if err == nil {
+		_, err = tx.Exec(`UPDATE password_reset_tokens SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL`, userID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change email"})
+		return
+	}
+
+	// Step 4: Give the old address a way back
+	if err := mailer.Default.Send(mailer.Message{
+		To:      oldEmail,
+		Subject: "Your FittrMe email address was changed",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"The email address of your FittrMe account was changed to %s.\n"+
+			"If this wasn't you, open this link within %d days to restore this address and sign out every device:\n%s",
+			username, newEmail, int(undoWindow.Hours()/24),
+			appLink("EMAIL_CHANGE_UNDO_URL", "http://localhost:8080/fittrme-api/me/email/undo", rawUndoToken)),
+	}); err != nil {
+		log.Println("Failed to send email change undo link:", err)
+	}
+
+	recordSecurityEvent(c, userID, models.EventEmailChanged, "", gin.H{"oldEmail": oldEmail, "newEmail": newEmail})
+	c.JSON(http.StatusOK, gin.H{"message": "Email address changed"})
+}
+
+// ShowEmailChangeUndo is the undo link sent to the previous address. It checks the link
+// and shows a confirmation form that posts to UndoEmailChange.
+func ShowEmailChangeUndo(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing token"})
+		return
+	}
+
+	var oldEmail string
+	err := database.DB.QueryRow(`
+	SELECT ec.old_email
+	FROM email_changes ec
+	JOIN users u ON u.user_id = ec.user_id
+	WHERE ec.undo_token_hash = $1 AND ec.undone_at IS NULL
+		AND ec.undo_expires_at > NOW() AND u.email = ec.new_email
+`, utils.HashToken(token)).Scan(&oldEmail)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired undo link"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	renderLinkConfirmation(c, linkConfirmation{
+		Title:   "Undo email change",
+		Heading: "Wasn't you?",
+		Text: "Undoing the change restores " + oldEmail + " as your email address and signs out every device " +
+			"and access token. You will need to reset your password.",
+		Button: "Undo the change",
+		Token:  token,
+	})
+}
+
+// UndoEmailChange restores the previous address when the form from ShowEmailChangeUndo is
+// submitted. Since an unwanted change suggests the account was taken over, every session
+// and personal access token is revoked and pending reset links are dropped.
+func UndoEmailChange(c *gin.Context) {
+	token := c.PostForm("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing token"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Step 1: Find the change; it can only be undone while the account still has the new address
+	var changeID, userID int
+	var oldEmail string
+	err = tx.QueryRow(`
+	SELECT ec.id, ec.user_id, ec.old_email
+	FROM email_changes ec
+	JOIN users u ON u.user_id = ec.user_id
+	WHERE ec.undo_token_hash = $1 AND ec.undone_at IS NULL
+		AND ec.undo_expires_at > NOW() AND u.email = ec.new_email
+	FOR UPDATE OF ec, u
+`, utils.HashToken(token)).Scan(&changeID, &userID, &oldEmail)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired undo link"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	taken, err := emailTaken(tx, oldEmail, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "This address now belongs to another account, please contact support"})
+		return
+	}
+
+	// Step 2: Restore the address and lock out whoever made the change
+	_, err = tx.Exec(`UPDATE users SET email = $1, email_verified_at = NOW() WHERE user_id = $2`, oldEmail, userID)
+	if err == nil {
+		_, err = tx.Exec(`UPDATE email_changes SET undone_at = NOW() WHERE id = $1`, changeID)
+	}
+	if err == nil {
+		_, err = tx.Exec(`UPDATE password_reset_tokens SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL`, userID)
+	}
+	if err == nil {
+		_, err = tx.Exec(`UPDATE api_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	}
+	if err == nil {
+		err = revokeOtherSessions(tx, userID, "")
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to undo email change"})
+		return
+	}
+
+	recordSecurityEvent(c, userID, models.EventEmailChangeUndone, "", gin.H{"restoredEmail": oldEmail})
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Your email address was restored and every device was signed out. Please reset your password.",
+	})
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fittrme-backend/database"
+	"fittrme-backend/jobs"
+	"fittrme-backend/models"
+	"fittrme-backend/utils"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Personal data exports run in the background (see jobs.StartExportWorker):
+//  1. RequestDataExport queues the export and returns its download link.
+//  2. GetDataExport reports progress; the link works once the status is "ready".
+//  3. DownloadDataExport serves the zip archive until it expires.
+
+const dataExportColumns = `id, status, error, size_bytes, requested_at, completed_at, expires_at`
+
+func scanDataExport(row interface{ Scan(...interface{}) error }) (models.DataExport, error) {
+	var e models.DataExport
+	err := row.Scan(&e.ID, &e.Status, &e.Error, &e.SizeBytes, &e.RequestedAt, &e.CompletedAt, &e.ExpiresAt)
+	return e, err
+}
+
+// RequestDataExport queues an archive of everything stored about the logged-in user.
+// The download link is only returned here, since just its hash is stored.
+func RequestDataExport(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+
+	// Step 1: One export at a time per user
+	var inProgress int
+	err := database.DB.QueryRow(`
+	SELECT id FROM data_exports WHERE user_id = $1 AND status IN ($2, $3) LIMIT 1
+`, userId, models.ExportPending, models.ExportRunning).Scan(&inProgress)
+	if err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "An export is already being prepared", "id": inProgress})
+		return
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Step 2: Queue it
+	rawToken, tokenHash, err := utils.NewOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	export, err := scanDataExport(database.DB.QueryRow(`
+	INSERT INTO data_exports (user_id, download_token_hash) VALUES ($1, $2)
+	RETURNING `+dataExportColumns, userId, tokenHash))
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		// Lost a race with another request; idx_data_exports_in_progress allows one export at a time
+		c.JSON(http.StatusConflict, gin.H{"error": "An export is already being prepared"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request export"})
+		return
+	}
+	jobs.WakeExportWorker()
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"export":      export,
+		"statusUrl":   fmt.Sprintf("/fittrme-api/me/exports/%d", export.ID),
+		"downloadUrl": appLink("DATA_EXPORT_DOWNLOAD_URL", "http://localhost:8080/fittrme-api/exports/download", rawToken),
+		"message": fmt.Sprintf("Your export is being prepared. The download link works once it is ready, for %d hours.",
+			int(jobs.DataExportTTL().Hours())),
+	})
+}
+
+// ListDataExports returns the logged-in user's exports, newest first.
+func ListDataExports(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+
+	rows, err := database.DB.Query(`
+	SELECT `+dataExportColumns+` FROM data_exports WHERE user_id = $1 ORDER BY requested_at DESC
+`, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	exports := []models.DataExport{}
+	for rows.Next() {
+		e, err := scanDataExport(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		exports = append(exports, e)
+	}
+	c.JSON(http.StatusOK, gin.H{"exports": exports})
+}
+
+// GetDataExport returns the status of one of the logged-in user's exports.
+func GetDataExport(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+	exportID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid export id"})
+		return
+	}
+
+	export, err := scanDataExport(database.DB.QueryRow(`
+	SELECT `+dataExportColumns+` FROM data_exports WHERE id = $1 AND user_id = $2
+`, exportID, userId))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Export not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"export": export})
+}
+
+// DownloadDataExport serves a finished export. The token in the link is the only
+// credential, so the link can be opened in a browser.
+func DownloadDataExport(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing token"})
+		return
+	}
+
+	var status string
+	var archive []byte
+	var expired bool
+	err := database.DB.QueryRow(`
+	SELECT status, archive, COALESCE(expires_at <= NOW(), FALSE)
+	FROM data_exports WHERE download_token_hash = $1
+`, utils.HashToken(token)).Scan(&status, &archive, &expired)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid download link"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	switch {
+	case status == models.ExportExpired || (status == models.ExportReady && expired):
+		c.JSON(http.StatusGone, gin.H{"error": "This download link has expired, please request a new export"})
+	case status == models.ExportFailed:
+		c.JSON(http.StatusGone, gin.H{"error": "Export failed, please request a new one"})
+	case status != models.ExportReady:
+		c.JSON(http.StatusConflict, gin.H{"error": "Your export is not ready yet", "status": status})
+	default:
+		c.Header("Content-Disposition", `attachment; filename="fittrme-export.zip"`)
+		c.Header("Cache-Control", "no-store")
+		c.Data(http.StatusOK, "application/zip", archive)
+	}
+}
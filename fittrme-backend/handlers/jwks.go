@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"fittrme-backend/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKS publishes the public keys access tokens can be verified with.
+func JWKS(c *gin.Context) {
+	jwks, err := utils.JWKS()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Signing keys unavailable"})
+		return
+	}
+
+	// Let verifiers cache the set briefly; a rotation publishes the new key well before it is used.
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, jwks)
+}
@@ -0,0 +1,375 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fittrme-backend/database"
+	"fittrme-backend/mailer"
+	"fittrme-backend/models"
+	"fittrme-backend/utils"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// New-device login alerts. Every login records a fingerprint of the device and of the
+// network it came from; when either has not been seen for the user before, a login alert
+// is created and emailed with a "this wasn't me" link. Reporting a login revokes its
+// session and blocks password logins until the password is reset.
+//
+// Fingerprints include the user ID and are stored as keyed hashes (utils.HashFingerprint),
+// so the table can neither be reversed nor used to follow a device or network across accounts.
+
+const (
+	fingerprintDevice  = "device"
+	fingerprintNetwork = "network"
+)
+
+// loginAlertReportWindow reads how long the "this wasn't me" link works from LOGIN_ALERT_REPORT_DAYS (default 7 days).
+func loginAlertReportWindow() time.Duration {
+	days, _ := strconv.Atoi(os.Getenv("LOGIN_ALERT_REPORT_DAYS"))
+	if days == 0 {
+		days = 7
+	}
+	return time.Hour * 24 * time.Duration(days)
+}
+
+// userAgentVersions matches version numbers, which change with every browser or app
+// update and would otherwise make a known device look new.
+var userAgentVersions = regexp.MustCompile(`\d+(\.\d+)*`)
+
+// deviceFingerprint identifies the device a login came from by its name and user agent.
+func deviceFingerprint(userID int, deviceName, userAgent string) string {
+	userAgent = userAgentVersions.ReplaceAllString(strings.ToLower(userAgent), "")
+	return utils.HashFingerprint(fmt.Sprintf("%s:%d:%s:%s", fingerprintDevice, userID, strings.ToLower(deviceName), userAgent))
+}
+
+// networkFingerprint identifies the network a login came from: the /24 of an IPv4
+// address or the /48 of an IPv6 address, so a new address from the same ISP pool
+// does not count as a new network.
+func networkFingerprint(userID int, ip string) string {
+	network := ip
+	if parsed := net.ParseIP(ip); parsed != nil {
+		if v4 := parsed.To4(); v4 != nil {
+			network = v4.Mask(net.CIDRMask(24, 32)).String()
+		} else {
+			network = parsed.Mask(net.CIDRMask(48, 128)).String()
+		}
+	}
+	return utils.HashFingerprint(fmt.Sprintf("%s:%d:%s", fingerprintNetwork, userID, network))
+}
+
+// rememberFingerprint records that the user logged in with a fingerprint and reports
+// whether it was new.
+func rememberFingerprint(db dbExecutor, userID int, kind, fingerprintHash string) (bool, error) {
+	res, err := db.Exec(`
+	INSERT INTO login_fingerprints (user_id, kind, fingerprint_hash) VALUES ($1, $2, $3)
+	ON CONFLICT (user_id, kind, fingerprint_hash) DO NOTHING
+`, userID, kind, fingerprintHash)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return true, nil
+	}
+	_, err = db.Exec(`
+	UPDATE login_fingerprints SET last_seen_at = NOW()
+	WHERE user_id = $1 AND kind = $2 AND fingerprint_hash = $3
+`, userID, kind, fingerprintHash)
+	return false, err
+}
+
+// checkNewDeviceLogin is called by completeLogin for every new session. The first
+// login of an account (or the first since alerts were introduced) only records the
+// fingerprints. Errors are logged, since a missed alert must not block the login.
+func checkNewDeviceLogin(c *gin.Context, user models.User, sessionID, deviceName string) {
+	var hasHistory bool
+	err := database.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM login_fingerprints WHERE user_id = $1)`, user.UserID).Scan(&hasHistory)
+	var newDevice, newNetwork bool
+	if err == nil {
+		newDevice, err = rememberFingerprint(database.DB, user.UserID, fingerprintDevice,
+			deviceFingerprint(user.UserID, deviceName, c.Request.UserAgent()))
+	}
+	if err == nil {
+		newNetwork, err = rememberFingerprint(database.DB, user.UserID, fingerprintNetwork,
+			networkFingerprint(user.UserID, c.ClientIP()))
+	}
+	if err != nil {
+		log.Println("Failed to check login device:", err)
+		return
+	}
+	if !hasHistory || (!newDevice && !newNetwork) {
+		return
+	}
+
+	// Raise the alert (shown in the app) and email it with the report link
+	rawToken, tokenHash, err := utils.NewOpaqueToken()
+	if err != nil {
+		log.Println("Failed to create login alert:", err)
+		return
+	}
+	window := loginAlertReportWindow()
+	_, err = database.DB.Exec(`
+	INSERT INTO login_alerts (user_id, session_id, device_name, ip_address, user_agent, new_device, new_network,
+		report_token_hash, report_expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+`, user.UserID, sessionID, deviceName, c.ClientIP(), c.Request.UserAgent(), newDevice, newNetwork,
+		tokenHash, time.Now().Add(window))
+	if err != nil {
+		log.Println("Failed to create login alert:", err)
+		return
+	}
+	recordSecurityEvent(c, user.UserID, models.EventNewDeviceLogin, sessionID, gin.H{
+		"newDevice":  newDevice,
+		"newNetwork": newNetwork,
+	})
+
+	if deviceName == "" {
+		deviceName = "Unknown device"
+	}
+	if err := mailer.Default.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "New sign-in to your FittrMe account",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Your FittrMe account was just signed in to from a new device or location:\n\n"+
+			"Device: %s\nBrowser/app: %s\nIP address: %s\nTime: %s\n\n"+
+			"If this was you, you can ignore this email.\n"+
+			"If this wasn't you, open this link within %d days to sign that device out and secure your account:\n%s",
+			user.Username, deviceName, c.Request.UserAgent(), c.ClientIP(), time.Now().UTC().Format(time.RFC1123),
+			int(window.Hours()/24), appLink("LOGIN_ALERT_REPORT_URL", "http://localhost:8080/fittrme-api/login-alerts/report", rawToken)),
+	}); err != nil {
+		log.Println("Failed to send login alert:", err)
+	}
+}
+
+// reportLogin handles "this wasn't me" for a login alert: it signs out the alert's
+// session, blocks password logins and emails a reset link. tx must hold the alert row.
+func reportLogin(c *gin.Context, tx *sql.Tx, alertID, userID int, sessionID string) {
+	_, err := tx.Exec(`UPDATE login_alerts SET reported_at = NOW(), read_at = COALESCE(read_at, NOW()) WHERE id = $1`, alertID)
+	if err == nil {
+		err = revokeSession(tx, sessionID)
+	}
+	if err == nil {
+		_, err = tx.Exec(`
+	UPDATE users SET password_reset_required_at = COALESCE(password_reset_required_at, NOW()) WHERE user_id = $1
+`, userID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to secure account"})
+		return
+	}
+	recordSecurityEvent(c, userID, models.EventLoginReported, sessionID, gin.H{"alertId": alertID})
+
+	// Whoever signed in knows the password, so the owner has to prove the email address to set a new one
+	user, err := findUserByID(userID)
+	var rawToken string
+	if err == nil {
+		rawToken, err = createPasswordResetToken(userID)
+	}
+	if err == nil {
+		err = sendPasswordResetEmail(user.Email, rawToken)
+	}
+	if err != nil {
+		log.Println("Failed to send password reset after reported login:", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "That device was signed out. We emailed you a link to choose a new password; " +
+			"password logins are blocked until you do.",
+	})
+}
+
+// ReportLoginAlert is the "this wasn't me" link from the login alert email. It checks the
+// link and shows a confirmation form that posts to ConfirmLoginAlertReport.
+func ReportLoginAlert(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing token"})
+		return
+	}
+
+	var valid bool
+	err := database.DB.QueryRow(`
+	SELECT EXISTS (
+		SELECT 1 FROM login_alerts
+		WHERE report_token_hash = $1 AND reported_at IS NULL AND report_expires_at > NOW()
+	)
+`, utils.HashToken(token)).Scan(&valid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired link"})
+		return
+	}
+
+	renderLinkConfirmation(c, linkConfirmation{
+		Title:   "Report a sign-in",
+		Heading: "Wasn't you?",
+		Text:    "Reporting this sign-in signs that device out and blocks password logins until you choose a new password.",
+		Button:  "Report this sign-in",
+		Token:   token,
+	})
+}
+
+// ConfirmLoginAlertReport makes the report when the form from ReportLoginAlert is submitted.
+func ConfirmLoginAlertReport(c *gin.Context) {
+	token := c.PostForm("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing token"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var alertID, userID int
+	var sessionID string
+	err = tx.QueryRow(`
+	SELECT id, user_id, session_id FROM login_alerts
+	WHERE report_token_hash = $1 AND reported_at IS NULL AND report_expires_at > NOW()
+	FOR UPDATE
+`, utils.HashToken(token)).Scan(&alertID, &userID, &sessionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired link"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	reportLogin(c, tx, alertID, userID, sessionID)
+}
+
+// loginAlertParam reads the :id path parameter of the /me/login-alerts routes and locks
+// the alert if it belongs to the logged-in user. It writes the error response itself
+// and returns false if the request should stop.
+func loginAlertParam(c *gin.Context, tx *sql.Tx, userID int) (alertID int, sessionID string, reported bool, ok bool) {
+	alertID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert id"})
+		return 0, "", false, false
+	}
+	err = tx.QueryRow(`
+	SELECT session_id, reported_at IS NOT NULL FROM login_alerts WHERE id = $1 AND user_id = $2 FOR UPDATE
+`, alertID, userID).Scan(&sessionID, &reported)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert not found"})
+		return 0, "", false, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return 0, "", false, false
+	}
+	return alertID, sessionID, reported, true
+}
+
+// ListLoginAlerts returns the logged-in user's login alerts, newest first.
+func ListLoginAlerts(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+
+	rows, err := database.DB.Query(`
+	SELECT id, session_id, device_name, ip_address, user_agent, new_device, new_network, created_at, read_at, reported_at
+	FROM login_alerts
+	WHERE user_id = $1
+	ORDER BY created_at DESC
+	LIMIT 100
+`, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	alerts := []models.LoginAlert{}
+	unread := 0
+	for rows.Next() {
+		var a models.LoginAlert
+		if err := rows.Scan(&a.ID, &a.SessionID, &a.DeviceName, &a.IPAddress, &a.UserAgent, &a.NewDevice,
+			&a.NewNetwork, &a.CreatedAt, &a.ReadAt, &a.ReportedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if a.ReadAt == nil {
+			unread++
+		}
+		alerts = append(alerts, a)
+	}
+	c.JSON(http.StatusOK, gin.H{"alerts": alerts, "unread": unread})
+}
+
+// MarkLoginAlertRead dismisses an alert in the app ("this was me").
+func MarkLoginAlertRead(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	alertID, _, _, ok := loginAlertParam(c, tx, userId)
+	if !ok {
+		return
+	}
+	_, err = tx.Exec(`UPDATE login_alerts SET read_at = COALESCE(read_at, NOW()) WHERE id = $1`, alertID)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update alert"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Alert dismissed"})
+}
+
+// ReportLoginAlertInApp is "this wasn't me" from the alert list in the app.
+func ReportLoginAlertInApp(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	alertID, sessionID, reported, ok := loginAlertParam(c, tx, userId)
+	if !ok {
+		return
+	}
+	if reported {
+		c.JSON(http.StatusConflict, gin.H{"error": "This login was already reported"})
+		return
+	}
+
+	reportLogin(c, tx, alertID, userId, sessionID)
+}
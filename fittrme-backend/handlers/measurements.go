@@ -0,0 +1,553 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fittrme-backend/database"
+	"fittrme-backend/models"
+	"fittrme-backend/utils"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Body composition and circumference measurements. Every measurement type (body fat,
+// waist, ...) is an entry of the measurement_types registry, and each reading is a row
+// of body_measurements. Readings work like weigh-ins: client-supplied measured_at,
+// paginated history, edits with optimistic concurrency (see weightedits.go) and soft
+// deletes that can be undone within jobs.EntryUndoWindow. Values are stored in the
+// type's unit and converted at the API boundary like weights (see units.go).
+
+// Measurement types derived values are computed from.
+const (
+	measurementBodyFat  = "body_fat"
+	measurementLeanMass = "lean_mass"
+	measurementWaist    = "waist"
+	measurementHip      = "hip"
+)
+
+// measurementTypeKey is the format of registry keys.
+var measurementTypeKey = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// measurementColumns is the column list scanMeasurement expects, in order. Queries
+// select it from a body_measurements row m joined to its measurement_types row mt.
+const measurementColumns = `m.id, m.user_id, m.type_key, m.value::FLOAT8, mt.unit, m.measured_at, m.version`
+
+// scanMeasurement scans a row selected with measurementColumns into a models.Measurement.
+func scanMeasurement(row interface{ Scan(...interface{}) error }) (models.Measurement, error) {
+	var m models.Measurement
+	var measuredAt time.Time
+	err := row.Scan(&m.ID, &m.UserID, &m.Type, &m.Value, &m.Unit, &measuredAt, &m.Version)
+	m.MeasuredAt = &measuredAt
+	return m, err
+}
+
+// measurementType looks up a registry entry; it returns sql.ErrNoRows for unknown keys.
+func measurementType(db dbExecutor, key string) (models.MeasurementType, error) {
+	var t models.MeasurementType
+	err := db.QueryRow(`
+	SELECT key, name, unit, min_value::FLOAT8, max_value::FLOAT8 FROM measurement_types WHERE key = $1
+`, key).Scan(&t.Key, &t.Name, &t.Unit, &t.MinValue, &t.MaxValue)
+	return t, err
+}
+
+// storedMeasurementValue converts a value sent in unit (the type's unit if empty) to
+// the unit of type t, which it is stored in. It writes the error response itself and
+// returns false if the unit does not fit the type.
+func storedMeasurementValue(c *gin.Context, t models.MeasurementType, value float64, unit string) (float64, bool) {
+	if unit == "" {
+		unit = t.Unit
+	}
+	value, storedUnit, ok := toStoredUnit(value, unit)
+	if !ok || storedUnit != t.Unit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s cannot be given in %s", t.Name, unit)})
+		return 0, false
+	}
+	return value, true
+}
+
+// checkMeasurementValue rejects values outside the type's plausible range, which
+// catches typos like 7.5 instead of 75. The range in the error is shown in the given
+// unit system. It writes the error response itself.
+func checkMeasurementValue(c *gin.Context, t models.MeasurementType, value float64, measuredAt time.Time, system string) bool {
+	if value < t.MinValue || value > t.MaxValue {
+		t = measurementTypeInUnits(t, system)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("%s must be between %g and %g %s", t.Name, t.MinValue, t.MaxValue, t.Unit),
+		})
+		return false
+	}
+	if measuredAt.After(time.Now().Add(weightMaxClockSkew)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "measured_at cannot be in the future"})
+		return false
+	}
+	return true
+}
+
+// measurementTypeInUnits converts the range of a measurement type to the given unit system.
+func measurementTypeInUnits(t models.MeasurementType, system string) models.MeasurementType {
+	t.MinValue, _ = fromStoredUnit(t.MinValue, t.Unit, system)
+	t.MaxValue, t.Unit = fromStoredUnit(t.MaxValue, t.Unit, system)
+	return t
+}
+
+// ListMeasurementTypes returns the measurement type registry, with the ranges in the
+// user's unit system.
+func ListMeasurementTypes(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+	system, ok := userUnitSystem(c, userId)
+	if !ok {
+		return
+	}
+
+	rows, err := database.DB.Query(`
+	SELECT key, name, unit, min_value::FLOAT8, max_value::FLOAT8 FROM measurement_types ORDER BY key
+`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	types := []models.MeasurementType{}
+	for rows.Next() {
+		var t models.MeasurementType
+		if err := rows.Scan(&t.Key, &t.Name, &t.Unit, &t.MinValue, &t.MaxValue); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		types = append(types, measurementTypeInUnits(t, system))
+	}
+	c.JSON(http.StatusOK, gin.H{"measurementTypes": types})
+}
+
+// SaveMeasurementType adds a measurement type to the registry or updates one.
+// The unit of an existing type cannot change, since its readings are stored in it.
+// Ranges are always given in the type's unit.
+func SaveMeasurementType(c *gin.Context) {
+	var input models.MeasurementTypeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !measurementTypeKey.MatchString(input.Key) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key may only contain lower case letters, digits and underscores"})
+		return
+	}
+
+	existing, err := measurementType(database.DB, input.Key)
+	if err == nil && existing.Unit != input.Unit {
+		c.JSON(http.StatusConflict, gin.H{"error": "The unit of an existing measurement type cannot be changed"})
+		return
+	} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	_, err = database.DB.Exec(`
+	INSERT INTO measurement_types (key, name, unit, min_value, max_value) VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (key) DO UPDATE SET name = EXCLUDED.name, min_value = EXCLUDED.min_value, max_value = EXCLUDED.max_value
+`, input.Key, input.Name, input.Unit, input.MinValue, input.MaxValue)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save measurement type"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Measurement type saved", "measurementType": models.MeasurementType(input)})
+}
+
+// SaveMeasurement records a reading. Like SaveWeight, posting the same type and
+// measured_at again updates that reading, so a client can safely retry.
+func SaveMeasurement(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+	system, ok := userUnitSystem(c, userId)
+	if !ok {
+		return
+	}
+
+	var input models.SaveMeasurementInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	t, err := measurementType(database.DB, input.Type)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown measurement type"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	value, ok := storedMeasurementValue(c, t, input.Value, input.Unit)
+	if !ok {
+		return
+	}
+	measuredAt := time.Now()
+	if input.MeasuredAt != nil {
+		measuredAt = *input.MeasuredAt
+	}
+	if !checkMeasurementValue(c, t, value, measuredAt, system) {
+		return
+	}
+
+	measurement, err := scanMeasurement(database.DB.QueryRow(`
+	WITH m AS (
+		INSERT INTO body_measurements (user_id, type_key, value, measured_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, type_key, measured_at) WHERE deleted_at IS NULL
+		DO UPDATE SET value = EXCLUDED.value, version = body_measurements.version + 1, updated_at = NOW()
+		RETURNING *
+	)
+	SELECT `+measurementColumns+` FROM m JOIN measurement_types mt ON mt.key = m.type_key
+`, userId, t.Key, value, measuredAt))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save measurement"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Measurement saved", "measurement": measurementInUnits(measurement, system)})
+}
+
+// ListMeasurements returns the user's readings, newest first, one page at a time.
+// Query parameters: type (comma separated, default all) and the from, to, limit,
+// cursor and units parameters of ListWeights.
+func ListMeasurements(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+
+	user, err := findUserByID(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	loc, err := time.LoadLocation(user.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	system, ok := unitSystem(c, user.Units)
+	if !ok {
+		return
+	}
+
+	// Step 1: Parse the filters
+	var q historyQuery
+	q.and("m.user_id = " + q.arg(userId))
+	q.and("m.deleted_at IS NULL")
+	limit, ok := pageLimit(c, weightsDefaultLimit, weightsMaxLimit)
+	if !ok {
+		return
+	}
+	if s := c.Query("type"); s != "" {
+		q.and("m.type_key = ANY(string_to_array(" + q.arg(s) + ", ','))")
+	}
+	if !historyFilters(c, &q, loc, "m.measured_at", "m.id") {
+		return
+	}
+	query := q.build(`SELECT `+measurementColumns+`
+	FROM body_measurements m JOIN measurement_types mt ON mt.key = m.type_key`, "m.measured_at DESC, m.id DESC", limit+1)
+
+	// Step 2: Fetch one extra row to tell whether there is another page
+	rows, err := database.DB.Query(query, q.args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	measurements := []models.Measurement{}
+	for rows.Next() {
+		m, err := scanMeasurement(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		measurements = append(measurements, measurementInUnits(m, system))
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	var nextCursor *string
+	if len(measurements) > limit {
+		measurements = measurements[:limit]
+		last := measurements[limit-1]
+		cursor := encodeHistoryCursor(*last.MeasuredAt, last.ID)
+		nextCursor = &cursor
+	}
+	c.JSON(http.StatusOK, gin.H{"measurements": measurements, "nextCursor": nextCursor})
+}
+
+// GetLatestMeasurements returns the latest reading of every measurement type plus the
+// values derived from them:
+//   - waistToHipRatio from the latest waist and hip circumferences
+//   - ffmi and normalizedFfmi (fat-free mass index) from the lean mass and the height
+//     of the latest weigh-in; without a lean mass reading, lean mass is estimated from
+//     the latest weight and body fat percentage
+//
+// Derived values are computed from the stored values; the FFMI is always in kg/m².
+func GetLatestMeasurements(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+	system, ok := userUnitSystem(c, userId)
+	if !ok {
+		return
+	}
+
+	rows, err := database.DB.Query(`
+	SELECT DISTINCT ON (m.type_key) `+measurementColumns+`
+	FROM body_measurements m JOIN measurement_types mt ON mt.key = m.type_key
+	WHERE m.user_id = $1 AND m.deleted_at IS NULL
+	ORDER BY m.type_key, m.measured_at DESC, m.id DESC
+`, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+	latest := map[string]models.Measurement{}
+	for rows.Next() {
+		m, err := scanMeasurement(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		latest[m.Type] = m
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Weight and height come from the latest weigh-in
+	var weightKg, heightCm sql.NullFloat64
+	err = database.DB.QueryRow(`
+	SELECT current_weight::FLOAT8, height::FLOAT8 FROM weights
+	WHERE user_id = $1 AND deleted_at IS NULL
+	ORDER BY measured_at DESC, id DESC
+	LIMIT 1
+`, userId).Scan(&weightKg, &heightCm)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	derived := gin.H{"waistToHipRatio": nil, "leanMass": nil, "leanMassUnit": nil, "leanMassSource": nil, "ffmi": nil, "normalizedFfmi": nil}
+	waist, haveWaist := latest[measurementWaist]
+	hip, haveHip := latest[measurementHip]
+	if haveWaist && haveHip {
+		derived["waistToHipRatio"] = round2(utils.WaistToHipRatio(waist.Value, hip.Value))
+	}
+
+	leanMass, leanSource := 0.0, ""
+	if m, ok := latest[measurementLeanMass]; ok {
+		leanMass, leanSource = m.Value, measurementLeanMass
+	} else if m, ok := latest[measurementBodyFat]; ok && weightKg.Valid && weightKg.Float64 > 0 {
+		leanMass, leanSource = utils.LeanMassFromBodyFat(weightKg.Float64, m.Value), measurementBodyFat
+	}
+	if leanSource != "" {
+		derived["leanMass"], derived["leanMassUnit"] = fromStoredUnit(leanMass, models.UnitKg, system)
+		derived["leanMassSource"] = leanSource
+		if heightCm.Valid && heightCm.Float64 > 0 {
+			ffmi, normalized := utils.FFMI(leanMass, heightCm.Float64)
+			derived["ffmi"] = round2(ffmi)
+			derived["normalizedFfmi"] = round2(normalized)
+		}
+	}
+
+	for key, m := range latest {
+		latest[key] = measurementInUnits(m, system)
+	}
+	c.JSON(http.StatusOK, gin.H{"latest": latest, "derived": derived})
+}
+
+// measurementIDParam reads the :id path parameter of the /measurements/:id routes.
+func measurementIDParam(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid measurement id"})
+		return 0, false
+	}
+	return id, true
+}
+
+var measurementEntries = entryKind[models.Measurement]{
+	table:      "body_measurements",
+	updatedAt:  "updated_at",
+	selectFrom: `SELECT ` + measurementColumns + ` FROM e m JOIN measurement_types mt ON mt.key = m.type_key`,
+	scan:       scanMeasurement,
+	idVersion:  func(m models.Measurement) (int64, int) { return m.ID, m.Version },
+	inUnits:    measurementInUnits,
+	idParam:    measurementIDParam,
+	key:        "measurement",
+	name:       "Measurement",
+	noun:       "measurement",
+	conflict:   "Another measurement of this type already has this measured_at",
+}
+
+// GetMeasurement returns one reading with its ETag.
+func GetMeasurement(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+	id, ok := measurementIDParam(c)
+	if !ok {
+		return
+	}
+	system, ok := userUnitSystem(c, userId)
+	if !ok {
+		return
+	}
+
+	m, err := scanMeasurement(database.DB.QueryRow(`
+	SELECT `+measurementColumns+`
+	FROM body_measurements m JOIN measurement_types mt ON mt.key = m.type_key
+	WHERE m.id = $1 AND m.user_id = $2 AND m.deleted_at IS NULL
+`, id, userId))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Measurement not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.Header("ETag", entryETag(m.ID, m.Version))
+	c.JSON(http.StatusOK, gin.H{"measurement": measurementInUnits(m, system)})
+}
+
+// UpdateMeasurement replaces a reading (PUT /measurements/:id).
+func UpdateMeasurement(c *gin.Context) {
+	var input models.UpdateMeasurementInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	editMeasurement(c, input.Version, func(m *models.Measurement, t models.MeasurementType) bool {
+		value, ok := storedMeasurementValue(c, t, input.Value, input.Unit)
+		m.Value = value
+		m.MeasuredAt = input.MeasuredAt
+		return ok
+	})
+}
+
+// PatchMeasurement changes only the fields present in the request (PATCH /measurements/:id).
+func PatchMeasurement(c *gin.Context) {
+	var input models.PatchMeasurementInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	editMeasurement(c, input.Version, func(m *models.Measurement, t models.MeasurementType) bool {
+		if input.Value != nil {
+			value, ok := storedMeasurementValue(c, t, *input.Value, input.Unit)
+			if !ok {
+				return false
+			}
+			m.Value = value
+		}
+		if input.MeasuredAt != nil {
+			m.MeasuredAt = input.MeasuredAt
+		}
+		return true
+	})
+}
+
+// editMeasurement applies an edit to the reading in the path if the client's version is
+// current. apply gets the reading's type for unit conversion; it writes the error
+// response itself and returns false if the edit is invalid.
+func editMeasurement(c *gin.Context, bodyVersion *int, apply func(*models.Measurement, models.MeasurementType) bool) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+	id, ok := measurementIDParam(c)
+	if !ok {
+		return
+	}
+	system, ok := userUnitSystem(c, userId)
+	if !ok {
+		return
+	}
+	wantVersion, ok := expectedVersion(c, id, bodyVersion)
+	if !ok {
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Step 1: Load the reading and check nobody changed it in the meantime
+	m, ok := lockEntry(c, tx, measurementEntries, id, userId, false)
+	if !ok || !checkEntryVersion(c, measurementEntries, m, wantVersion, system) {
+		return
+	}
+
+	// Step 2: Apply and validate the edit
+	t, err := measurementType(tx, m.Type)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !apply(&m, t) || !checkMeasurementValue(c, t, m.Value, *m.MeasuredAt, system) {
+		return
+	}
+
+	// Step 3: Save it as the next version
+	m, err = scanMeasurement(tx.QueryRow(`
+	WITH e AS (
+		UPDATE body_measurements SET value = $1, measured_at = $2, version = version + 1, updated_at = NOW()
+		WHERE id = $3
+		RETURNING *
+	)
+	`+measurementEntries.selectFrom, m.Value, *m.MeasuredAt, id))
+	if err == nil {
+		err = tx.Commit()
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		c.JSON(http.StatusConflict, gin.H{"error": measurementEntries.conflict})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update measurement"})
+		return
+	}
+
+	c.Header("ETag", entryETag(m.ID, m.Version))
+	c.JSON(http.StatusOK, gin.H{"message": "Measurement updated", "measurement": measurementInUnits(m, system)})
+}
+
+// DeleteMeasurement soft-deletes a reading; see softDeleteEntry.
+func DeleteMeasurement(c *gin.Context) {
+	softDeleteEntry(c, measurementEntries)
+}
+
+// RestoreMeasurement undoes DeleteMeasurement within the undo window.
+func RestoreMeasurement(c *gin.Context) {
+	restoreEntry(c, measurementEntries)
+}
@@ -0,0 +1,452 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fittrme-backend/database"
+	"fittrme-backend/models"
+	"fittrme-backend/utils"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// mfaChallengeTTL is how long the user has to enter a code after the password step.
+	mfaChallengeTTL = 5 * time.Minute
+	// mfaChallengeMaxAttempts is how many wrong codes a single challenge accepts before it is burned.
+	mfaChallengeMaxAttempts = 5
+	// recoveryCodeCount is how many recovery codes are issued at once.
+	recoveryCodeCount = 10
+)
+
+// totpIssuer is the account issuer shown in authenticator apps (TOTP_ISSUER, default "FittrMe").
+func totpIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "FittrMe"
+}
+
+// mfaEnrolled reports whether the user has confirmed TOTP enrollment.
+func mfaEnrolled(userID int) (bool, error) {
+	var enrolled bool
+	err := database.DB.QueryRow(`
+	SELECT EXISTS(SELECT 1 FROM user_totp WHERE user_id = $1 AND confirmed_at IS NOT NULL)
+`, userID).Scan(&enrolled)
+	return enrolled, err
+}
+
+// startMFAChallenge answers a correct password for an enrolled user with a
+// short-lived, single-use challenge token instead of the token pair.
+func startMFAChallenge(c *gin.Context, userID int, deviceName string) {
+	rawToken, tokenHash, err := utils.NewOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate MFA challenge"})
+		return
+	}
+
+	_, err = database.DB.Exec(`
+	INSERT INTO mfa_challenges (user_id, token_hash, device_name, expires_at)
+	VALUES ($1, $2, $3, $4)
+`, userID, tokenHash, deviceName, time.Now().Add(mfaChallengeTTL))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store MFA challenge"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Two-factor authentication required",
+		"mfaRequired": true,
+		"mfaToken":    rawToken,
+		"expiresIn":   int(mfaChallengeTTL.Seconds()),
+	})
+}
+
+// verifyMFACode accepts either a TOTP code or an unused recovery code for userID.
+// A TOTP code is only accepted once (its time step must be newer than the last one used),
+// and a recovery code is consumed. It returns which method matched.
+func verifyMFACode(tx *sql.Tx, userID int, code string) (method string, ok bool, err error) {
+	var secretEncrypted string
+	var lastUsedStep int64
+	err = tx.QueryRow(`
+	SELECT secret_encrypted, last_used_step FROM user_totp
+	WHERE user_id = $1 AND confirmed_at IS NOT NULL
+	FOR UPDATE
+`, userID).Scan(&secretEncrypted, &lastUsedStep)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+
+	secret, err := utils.DecryptSecret(secretEncrypted)
+	if err != nil {
+		return "", false, err
+	}
+	if step, valid := utils.ValidateTOTP(secret, code, time.Now()); valid && step > lastUsedStep {
+		_, err = tx.Exec(`UPDATE user_totp SET last_used_step = $1 WHERE user_id = $2`, step, userID)
+		return "totp", err == nil, err
+	}
+
+	res, err := tx.Exec(`
+	UPDATE mfa_recovery_codes SET used_at = NOW()
+	WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+`, userID, utils.HashToken(utils.NormalizeRecoveryCode(code)))
+	if err != nil {
+		return "", false, err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return "recovery", true, nil
+	}
+	return "", false, nil
+}
+
+// replaceRecoveryCodes discards the user's recovery codes and issues a new set.
+// The raw codes are returned once; only their hashes are stored.
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := utils.NewRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`
+	INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)
+`, userID, utils.HashToken(utils.NormalizeRecoveryCode(code)))
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// EnrollTOTP starts TOTP enrollment: it generates a new secret and returns it with
+// the otpauth:// URI for the authenticator app. Enrollment only takes effect after ConfirmTOTP.
+func EnrollTOTP(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+
+	user, err := findUserByID(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	secret, err := utils.NewTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+	secretEncrypted, err := utils.EncryptSecret(secret)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store secret"})
+		return
+	}
+
+	// Replace any earlier unconfirmed enrollment, but never a confirmed one
+	res, err := database.DB.Exec(`
+	INSERT INTO user_totp (user_id, secret_encrypted)
+	VALUES ($1, $2)
+	ON CONFLICT (user_id) DO UPDATE SET
+		secret_encrypted = EXCLUDED.secret_encrypted,
+		last_used_step = 0,
+		created_at = NOW()
+	WHERE user_totp.confirmed_at IS NULL
+`, userId, secretEncrypted)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store secret"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Scan the QR code with your authenticator app, then confirm with a code",
+		"secret":     secret,
+		"otpauthUri": utils.TOTPURI(totpIssuer(), user.Email, secret),
+	})
+}
+
+// ConfirmTOTP finishes enrollment once the user proves their app produces valid codes,
+// and returns a fresh set of recovery codes (shown only this once).
+func ConfirmTOTP(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+
+	var input models.MFACodeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var secretEncrypted string
+	var confirmedAt sql.NullTime
+	err = tx.QueryRow(`
+	SELECT secret_encrypted, confirmed_at FROM user_totp WHERE user_id = $1 FOR UPDATE
+`, userId).Scan(&secretEncrypted, &confirmedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start two-factor enrollment first"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if confirmedAt.Valid {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := utils.DecryptSecret(secretEncrypted)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read secret"})
+		return
+	}
+	step, valid := utils.ValidateTOTP(secret, input.Code, time.Now())
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	_, err = tx.Exec(`
+	UPDATE user_totp SET confirmed_at = NOW(), last_used_step = $1 WHERE user_id = $2
+`, step, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+		return
+	}
+	codes, err := replaceRecoveryCodes(tx, userId)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+		return
+	}
+
+	recordSecurityEvent(c, userId, models.EventMFAEnabled, "", gin.H{"method": "totp"})
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Two-factor authentication enabled. Store these recovery codes somewhere safe",
+		"recoveryCodes": codes,
+	})
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes; it requires a current code.
+func RegenerateRecoveryCodes(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+
+	var input models.MFACodeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	_, valid, err := verifyMFACode(tx, userId, input.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !valid {
+		recordSecurityEvent(c, userId, models.EventMFAFailed, "", gin.H{"reason": "invalid_code"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userId)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+
+	recordSecurityEvent(c, userId, models.EventRecoveryCodesReplaced, "", nil)
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+// DisableTOTP turns two-factor authentication off. It requires both the
+// current password and a valid code, so a stolen session alone cannot do it.
+func DisableTOTP(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+
+	var input models.DisableMFAInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := findUserByID(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if ok, _, _ := utils.VerifyPassword(user.PasswordHash, input.Password); !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	_, valid, err := verifyMFACode(tx, userId, input.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !valid {
+		recordSecurityEvent(c, userId, models.EventMFAFailed, "", gin.H{"reason": "invalid_code"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	_, err = tx.Exec(`DELETE FROM user_totp WHERE user_id = $1`, userId)
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userId)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+
+	recordSecurityEvent(c, userId, models.EventMFADisabled, "", gin.H{"method": "totp"})
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// LoginMFA completes a two-step login: it exchanges the challenge token from
+// LoginUser plus a TOTP or recovery code for the access/refresh token pair.
+func LoginMFA(c *gin.Context) {
+	var input models.MFALoginInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Step 1: Find the pending challenge, locking it so it can only be completed once
+	var challengeID, userID int
+	var deviceName string
+	err = tx.QueryRow(`
+	SELECT id, user_id, device_name FROM mfa_challenges
+	WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+	FOR UPDATE
+`, utils.HashToken(input.MFAToken)).Scan(&challengeID, &userID, &deviceName)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA challenge, please log in again"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Step 2: Check the code. Wrong codes count against the challenge, which is
+	// burned after too many attempts, and against the account's login throttle,
+	// so the 6-digit space cannot be brute forced with fresh challenges either.
+	wait, err := loginRetryAfter(accountThrottleKey(userID, ""), c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if wait > 0 {
+		respondTooManyAttempts(c, wait)
+		return
+	}
+
+	method, valid, err := verifyMFACode(tx, userID, input.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !valid {
+		if err := recordLoginFailure(accountThrottleKey(userID, ""), userID, c.ClientIP()); err != nil {
+			log.Println("Failed to record login failure:", err)
+		}
+		_, err = tx.Exec(`
+	UPDATE mfa_challenges
+	SET attempts = attempts + 1,
+		used_at = CASE WHEN attempts + 1 >= $2 THEN NOW() END
+	WHERE id = $1
+`, challengeID, mfaChallengeMaxAttempts)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		recordSecurityEvent(c, userID, models.EventMFAFailed, "", gin.H{"reason": "invalid_code"})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	// Step 3: Consume the challenge and finish the login like a password-only one
+	_, err = tx.Exec(`UPDATE mfa_challenges SET used_at = NOW() WHERE id = $1`, challengeID)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	user, err := findUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if method == "recovery" {
+		recordSecurityEvent(c, userID, models.EventRecoveryCodeUsed, "", nil)
+	}
+	completeLogin(c, user, deviceName, "mfa:"+method)
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"fittrme-backend/database"
+	"fittrme-backend/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mfaUser creates a user with confirmed two-factor authentication and its recovery codes.
+func mfaUser(t *testing.T) (userID int, secret string, recoveryCodes []string) {
+	t.Helper()
+	t.Setenv("SECRETS_ENCRYPTION_KEY", "test-secrets-key")
+	user, err := createUser(database.DB, "mfa-user", "mfa@example.com", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err = utils.NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := utils.EncryptSecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.DB.Exec(`
+	INSERT INTO user_totp (user_id, secret_encrypted, confirmed_at) VALUES ($1, $2, NOW())
+`, user.UserID, encrypted); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	recoveryCodes, err = replaceRecoveryCodes(tx, user.UserID)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.UserID, secret, recoveryCodes
+}
+
+// verify runs verifyMFACode in its own transaction, like LoginMFA.
+func verify(t *testing.T, userID int, code string) string {
+	t.Helper()
+	tx, err := database.DB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	method, ok, err := verifyMFACode(tx, userID, code)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		return ""
+	}
+	return method
+}
+
+func TestVerifyMFACodeRejectsReplay(t *testing.T) {
+	useTestDB(t)
+	userID, secret, _ := mfaUser(t)
+
+	step := utils.TOTPStep(time.Now())
+	current, err := utils.TOTPCode(secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, err := utils.TOTPCode(secret, step-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if method := verify(t, userID, current); method != "totp" {
+		t.Fatalf("current code: method %q, want totp", method)
+	}
+	if method := verify(t, userID, current); method != "" {
+		t.Fatalf("replayed code accepted as %q", method)
+	}
+	// Still inside the window, but older than the code already used
+	if method := verify(t, userID, previous); method != "" {
+		t.Fatalf("older code accepted as %q", method)
+	}
+}
+
+func TestVerifyMFACodeConsumesRecoveryCode(t *testing.T) {
+	useTestDB(t)
+	userID, _, codes := mfaUser(t)
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(codes), recoveryCodeCount)
+	}
+
+	// Typed by hand: upper case, spaces instead of the dash
+	typed := strings.ToUpper(strings.ReplaceAll(codes[0], "-", " "))
+	if method := verify(t, userID, typed); method != "recovery" {
+		t.Fatalf("recovery code: method %q, want recovery", method)
+	}
+	if method := verify(t, userID, codes[0]); method != "" {
+		t.Fatalf("used recovery code accepted again as %q", method)
+	}
+	if method := verify(t, userID, codes[1]); method != "recovery" {
+		t.Fatalf("second recovery code: method %q, want recovery", method)
+	}
+	if method := verify(t, userID, "aaaaa-bbbbb"); method != "" {
+		t.Fatalf("unknown recovery code accepted as %q", method)
+	}
+}
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fittrme-backend/database"
+	"fittrme-backend/models"
+	"fittrme-backend/oidc"
+	"fittrme-backend/utils"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// oidcStateTTL is how long the user has to finish signing in at the provider.
+const oidcStateTTL = 10 * time.Minute
+
+// errNoVerifiedEmail means the provider did not vouch for an email address,
+// so the identity can neither be linked to an account nor create one.
+var errNoVerifiedEmail = errors.New("identity provider did not share a verified email address")
+
+// ListOIDCProviders returns the names of the configured identity providers,
+// so the app knows which "Sign in with ..." buttons to show.
+func ListOIDCProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"providers": oidc.Names()})
+}
+
+// StartOIDCLogin begins an authorization code + PKCE login with an external provider.
+// The state, nonce and code verifier stay on the server; the app opens the returned
+// URL in a browser and posts the code and state it is redirected back with to OIDCCallback.
+func StartOIDCLogin(c *gin.Context) {
+	// Step 1: Look up the provider
+	provider, err := oidc.Lookup(c.Param("provider"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown identity provider"})
+		return
+	}
+
+	var input models.OIDCStartInput
+	if err := c.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Step 2: Generate the state (stored hashed), nonce and PKCE code verifier
+	state, stateHash, err := utils.NewOpaqueToken()
+	var nonce, codeVerifier string
+	if err == nil {
+		nonce, _, err = utils.NewOpaqueToken()
+	}
+	if err == nil {
+		codeVerifier, _, err = utils.NewOpaqueToken()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sign-in"})
+		return
+	}
+
+	// Step 3: Build the authorization URL (fetches the provider's discovery document once)
+	authURL, err := provider.AuthCodeURL(c.Request.Context(), state, nonce, codeVerifier)
+	if err != nil {
+		log.Println("OIDC discovery failed:", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is unavailable"})
+		return
+	}
+
+	// Step 4: Remember this attempt until the callback
+	_, err = database.DB.Exec(`DELETE FROM oidc_login_states WHERE expires_at < NOW()`)
+	if err == nil {
+		_, err = database.DB.Exec(`
+	INSERT INTO oidc_login_states (state_hash, provider, nonce, code_verifier, device_name, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+`, stateHash, provider.Name, nonce, codeVerifier, input.DeviceName, time.Now().Add(oidcStateTTL))
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sign-in"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"authorizationUrl": authURL,
+		"state":            state,
+		"expiresIn":        int(oidcStateTTL.Seconds()),
+	})
+}
+
+// OIDCCallback finishes an external login: it redeems the authorization code,
+// validates the ID token, finds or creates the local account and signs it in
+// exactly like LoginUser.
+func OIDCCallback(c *gin.Context) {
+	provider, err := oidc.Lookup(c.Param("provider"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown identity provider"})
+		return
+	}
+
+	var input models.OIDCCallbackInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Step 1: Consume the login state; each state can be redeemed once
+	var nonce, codeVerifier, deviceName string
+	var expiresAt time.Time
+	err = database.DB.QueryRow(`
+	DELETE FROM oidc_login_states WHERE state_hash = $1 AND provider = $2
+	RETURNING nonce, code_verifier, device_name, expires_at
+`, utils.HashToken(input.State), provider.Name).Scan(&nonce, &codeVerifier, &deviceName, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && time.Now().After(expiresAt)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired sign-in attempt"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Step 2: Exchange the code and validate the ID token against the provider's JWKS
+	claims, err := provider.Exchange(c.Request.Context(), input.Code, codeVerifier, nonce)
+	if err != nil {
+		log.Printf("OIDC sign-in with %s failed: %v", provider.Name, err)
+		recordSecurityEvent(c, 0, models.EventLoginFailed, "", gin.H{"method": "oidc:" + provider.Name, "reason": "provider_rejected"})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign-in with the identity provider failed"})
+		return
+	}
+
+	// Step 3: Find the linked account, link one by verified email, or create one
+	user, err := resolveOIDCUser(provider.Name, claims)
+	if errors.Is(err, errNoVerifiedEmail) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "The identity provider did not share a verified email address"})
+		return
+	} else if err != nil {
+		log.Println("Failed to resolve OIDC user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+		return
+	}
+
+	// Step 4: Same ending as a password login, including the second factor
+	enrolled, err := mfaEnrolled(user.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if enrolled {
+		startMFAChallenge(c, user.UserID, deviceName)
+		return
+	}
+
+	completeLogin(c, user, deviceName, "oidc:"+provider.Name)
+}
+
+// resolveOIDCUser maps an external identity to a local user.
+func resolveOIDCUser(provider string, claims *oidc.Claims) (models.User, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return models.User{}, err
+	}
+	defer tx.Rollback()
+
+	// An identity that was linked before always wins, even if its email changed since
+	var userID int
+	err = tx.QueryRow(`
+	UPDATE user_identities SET last_login_at = NOW()
+	WHERE provider = $1 AND subject = $2
+	RETURNING user_id
+`, provider, claims.Subject).Scan(&userID)
+	if err == nil {
+		if err := tx.Commit(); err != nil {
+			return models.User{}, err
+		}
+		user, err := findUserByID(userID)
+		if err != nil {
+			return models.User{}, err
+		}
+		return user, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return models.User{}, err
+	}
+
+	// Without a verified email we cannot safely link or create an account
+	email := normalizeEmail(claims.Email)
+	if email == "" || !claims.EmailVerified {
+		return models.User{}, errNoVerifiedEmail
+	}
+
+	user, err := scanUser(tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE email = $1 FOR UPDATE`, email))
+	switch {
+	case err == nil:
+		// Someone may have signed up with this address without owning it. Now that the
+		// provider proves ownership, lock that person out: drop the password, second
+		// factor and sessions they set up, then mark the address verified.
+		if user.EmailVerifiedAt == nil {
+			_, err = tx.Exec(`UPDATE users SET password_hash = '', email_verified_at = NOW() WHERE user_id = $1`, user.UserID)
+			if err == nil {
+				_, err = tx.Exec(`DELETE FROM user_totp WHERE user_id = $1`, user.UserID)
+			}
+			if err == nil {
+				_, err = tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = $1`, user.UserID)
+			}
+			if err == nil {
+				err = revokeOtherSessions(tx, user.UserID, "")
+			}
+			if err == nil {
+				user, err = scanUser(tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE user_id = $1`, user.UserID))
+			}
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		var username string
+		username, err = availableUsername(tx, email)
+		if err == nil {
+			user, err = createUser(tx, username, email, "", true)
+		}
+	}
+	if err == nil {
+		_, err = tx.Exec(`
+	INSERT INTO user_identities (user_id, provider, subject, email, last_login_at)
+	VALUES ($1, $2, $3, $4, NOW())
+`, user.UserID, provider, claims.Subject, email)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+var usernameUnsafeChars = regexp.MustCompile(`[^a-z0-9._]+`)
+
+// availableUsername derives an unused username from the local part of an email address.
+func availableUsername(db dbExecutor, email string) (string, error) {
+	base := usernameUnsafeChars.ReplaceAllString(strings.SplitN(email, "@", 2)[0], "")
+	if base == "" {
+		base = "user"
+	}
+
+	candidate := base
+	for i := 0; ; i++ {
+		var exists bool
+		if err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(username) = LOWER($1))`, candidate).Scan(&exists); err != nil {
+			return "", err
+		}
+		if !exists {
+			return candidate, nil
+		}
+		if i == 10 {
+			return "", errors.New("no free username for " + base)
+		}
+		suffix, err := utils.NewRecoveryCode()
+		if err != nil {
+			return "", err
+		}
+		candidate = base + suffix[:4]
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"errors"
+	"fittrme-backend/database"
+	"fittrme-backend/oidc"
+	"testing"
+)
+
+func identityOwner(t *testing.T, provider, subject string) int {
+	t.Helper()
+	var userID int
+	if err := database.DB.QueryRow(`SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2`,
+		provider, subject).Scan(&userID); err != nil {
+		t.Fatalf("identity %s/%s: %v", provider, subject, err)
+	}
+	return userID
+}
+
+func TestResolveOIDCUserCreatesAccount(t *testing.T) {
+	useTestDB(t)
+
+	user, err := resolveOIDCUser("google", &oidc.Claims{Subject: "g-1", Email: " Jane@Example.com", EmailVerified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "jane@example.com" || user.EmailVerifiedAt == nil || user.PasswordHash != "" {
+		t.Fatalf("created user = %+v", user)
+	}
+	if owner := identityOwner(t, "google", "g-1"); owner != user.UserID {
+		t.Fatalf("identity linked to %d, want %d", owner, user.UserID)
+	}
+
+	// A linked identity wins even after its email changed at the provider
+	again, err := resolveOIDCUser("google", &oidc.Claims{Subject: "g-1", Email: "new@example.com", EmailVerified: true})
+	if err != nil || again.UserID != user.UserID {
+		t.Fatalf("second login: user %d, err %v; want user %d", again.UserID, err, user.UserID)
+	}
+}
+
+func TestResolveOIDCUserLinksByVerifiedEmail(t *testing.T) {
+	useTestDB(t)
+	existing, err := createUser(database.DB, "jane", "jane@example.com", "hash", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := resolveOIDCUser("apple", &oidc.Claims{Subject: "a-1", Email: "JANE@example.com", EmailVerified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.UserID != existing.UserID {
+		t.Fatalf("linked to user %d, want %d", user.UserID, existing.UserID)
+	}
+	// The owner verified the address themselves, so their password stays
+	if user.PasswordHash != "hash" {
+		t.Fatal("password of a verified account was dropped")
+	}
+	if owner := identityOwner(t, "apple", "a-1"); owner != existing.UserID {
+		t.Fatalf("identity linked to %d, want %d", owner, existing.UserID)
+	}
+}
+
+func TestResolveOIDCUserTakesOverUnverifiedAccount(t *testing.T) {
+	useTestDB(t)
+	squatter, err := createUser(database.DB, "squatter", "jane@example.com", "hash", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := resolveOIDCUser("google", &oidc.Claims{Subject: "g-2", Email: "jane@example.com", EmailVerified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.UserID != squatter.UserID {
+		t.Fatalf("linked to user %d, want %d", user.UserID, squatter.UserID)
+	}
+	// Whoever signed up without proving the address loses access
+	if user.PasswordHash != "" || user.EmailVerifiedAt == nil {
+		t.Fatalf("unverified account kept its password or stayed unverified: %+v", user)
+	}
+}
+
+func TestResolveOIDCUserRequiresVerifiedEmail(t *testing.T) {
+	useTestDB(t)
+	existing, err := createUser(database.DB, "jane", "jane@example.com", "hash", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, claims := range []*oidc.Claims{
+		{Subject: "g-3", Email: "jane@example.com", EmailVerified: false},
+		{Subject: "g-4", Email: "", EmailVerified: true},
+	} {
+		if _, err := resolveOIDCUser("google", claims); !errors.Is(err, errNoVerifiedEmail) {
+			t.Fatalf("claims %+v: err = %v, want errNoVerifiedEmail", claims, err)
+		}
+	}
+
+	var identities, users int
+	database.DB.QueryRow(`SELECT COUNT(*) FROM user_identities`).Scan(&identities)
+	database.DB.QueryRow(`SELECT COUNT(*) FROM users WHERE user_id <> $1`, existing.UserID).Scan(&users)
+	if identities != 0 || users != 0 {
+		t.Fatalf("unverified email linked %d identities and created %d users", identities, users)
+	}
+}
@@ -0,0 +1,364 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fittrme-backend/database"
+	"fittrme-backend/models"
+	"fittrme-backend/webauthn"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// passkeyChallengeTTL is how long a registration or login ceremony may take.
+const passkeyChallengeTTL = 5 * time.Minute
+
+// Ceremony names stored with each challenge, so a registration challenge cannot be used to log in.
+const (
+	ceremonyRegistration = "registration"
+	ceremonyLogin        = "login"
+)
+
+// passkeyUserHandle is the WebAuthn user.id for an account. It is returned as
+// userHandle by discoverable credentials during login.
+func passkeyUserHandle(userID int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(userID)))
+}
+
+// newPasskeyChallenge stores a fresh challenge for a ceremony. userID is 0 for logins,
+// where the user is only known once the authenticator has answered.
+func newPasskeyChallenge(userID int, ceremony string) (challengeID, challenge string, err error) {
+	challengeID, err = webauthn.NewChallenge()
+	if err == nil {
+		challenge, err = webauthn.NewChallenge()
+	}
+	if err == nil {
+		_, err = database.DB.Exec(`DELETE FROM webauthn_challenges WHERE expires_at < NOW()`)
+	}
+	if err == nil {
+		_, err = database.DB.Exec(`
+	INSERT INTO webauthn_challenges (id, user_id, ceremony, challenge, expires_at)
+	VALUES ($1, NULLIF($2, 0), $3, $4, $5)
+`, challengeID, userID, ceremony, challenge, time.Now().Add(passkeyChallengeTTL))
+	}
+	return challengeID, challenge, err
+}
+
+// consumePasskeyChallenge redeems a challenge once. It returns sql.ErrNoRows if the
+// challenge does not exist, has expired, or belongs to another ceremony or user.
+func consumePasskeyChallenge(challengeID string, userID int, ceremony string) (string, error) {
+	var challenge string
+	err := database.DB.QueryRow(`
+	DELETE FROM webauthn_challenges
+	WHERE id = $1 AND COALESCE(user_id, 0) = $2 AND ceremony = $3 AND expires_at > NOW()
+	RETURNING challenge
+`, challengeID, userID, ceremony).Scan(&challenge)
+	return challenge, err
+}
+
+// BeginPasskeyRegistration returns the options for navigator.credentials.create()
+// to add a passkey to the logged-in account.
+func BeginPasskeyRegistration(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+
+	user, err := findUserByID(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Step 1: Exclude the user's existing passkeys so an authenticator isn't registered twice
+	var credentialIDs []string
+	err = database.DB.QueryRow(`
+	SELECT COALESCE(array_agg(credential_id), '{}') FROM passkeys WHERE user_id = $1
+`, userId).Scan(pq.Array(&credentialIDs))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	exclude := make([]gin.H, 0, len(credentialIDs))
+	for _, id := range credentialIDs {
+		exclude = append(exclude, gin.H{"type": "public-key", "id": id})
+	}
+
+	// Step 2: Store the challenge
+	challengeID, challenge, err := newPasskeyChallenge(userId, ceremonyRegistration)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey registration"})
+		return
+	}
+
+	cfg := webauthn.ConfigFromEnv()
+	params := make([]gin.H, 0, len(webauthn.SupportedAlgorithms))
+	for _, alg := range webauthn.SupportedAlgorithms {
+		params = append(params, gin.H{"type": "public-key", "alg": alg})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"challengeId": challengeID,
+		"publicKey": gin.H{
+			"rp":                 gin.H{"id": cfg.RPID, "name": cfg.RPName},
+			"user":               gin.H{"id": passkeyUserHandle(user.UserID), "name": user.Username, "displayName": user.Username},
+			"challenge":          challenge,
+			"pubKeyCredParams":   params,
+			"timeout":            passkeyChallengeTTL.Milliseconds(),
+			"excludeCredentials": exclude,
+			"authenticatorSelection": gin.H{
+				"residentKey":      "required",
+				"userVerification": "required",
+			},
+			"attestation": "none",
+		},
+	})
+}
+
+// FinishPasskeyRegistration verifies the authenticator's response and stores the passkey.
+func FinishPasskeyRegistration(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+
+	var input models.PasskeyRegistrationInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Step 1: Redeem the challenge issued to this user
+	challenge, err := consumePasskeyChallenge(input.ChallengeID, userId, ceremonyRegistration)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired passkey challenge"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Step 2: Verify the attestation response
+	clientDataJSON, err := webauthn.DecodeBase64URL(input.Credential.Response.ClientDataJSON)
+	var attestationObject []byte
+	if err == nil {
+		attestationObject, err = webauthn.DecodeBase64URL(input.Credential.Response.AttestationObject)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid credential encoding"})
+		return
+	}
+	credential, err := webauthn.ConfigFromEnv().VerifyRegistration(challenge, clientDataJSON, attestationObject)
+	if err != nil {
+		log.Println("Passkey registration failed:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Passkey could not be verified"})
+		return
+	}
+
+	// Step 3: Store it
+	name := input.Name
+	if name == "" {
+		name = "Passkey"
+	}
+	transports := input.Credential.Response.Transports
+	if transports == nil {
+		transports = []string{}
+	}
+	passkey := models.Passkey{Name: name, Transports: transports}
+	err = database.DB.QueryRow(`
+	INSERT INTO passkeys (user_id, credential_id, public_key, sign_count, transports, aaguid, name)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	ON CONFLICT (credential_id) DO NOTHING
+	RETURNING id, created_at
+`, userId, base64.RawURLEncoding.EncodeToString(credential.ID), credential.PublicKey, int64(credential.SignCount),
+		pq.Array(transports), base64.RawURLEncoding.EncodeToString(credential.AAGUID), name).Scan(&passkey.ID, &passkey.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusConflict, gin.H{"error": "This passkey is already registered"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save passkey"})
+		return
+	}
+
+	recordSecurityEvent(c, userId, models.EventPasskeyAdded, "", gin.H{"passkeyId": passkey.ID, "name": name})
+	c.JSON(http.StatusCreated, gin.H{"message": "Passkey added", "passkey": passkey})
+}
+
+// ListPasskeys returns the logged-in user's passkeys.
+func ListPasskeys(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+
+	rows, err := database.DB.Query(`
+	SELECT id, name, transports, created_at, last_used_at
+	FROM passkeys
+	WHERE user_id = $1
+	ORDER BY created_at DESC
+`, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	passkeys := []models.Passkey{}
+	for rows.Next() {
+		var p models.Passkey
+		if err := rows.Scan(&p.ID, &p.Name, pq.Array(&p.Transports), &p.CreatedAt, &p.LastUsedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		passkeys = append(passkeys, p)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"passkeys": passkeys})
+}
+
+// DeletePasskey removes one of the logged-in user's passkeys.
+func DeletePasskey(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+	passkeyID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid passkey id"})
+		return
+	}
+
+	res, err := database.DB.Exec(`DELETE FROM passkeys WHERE id = $1 AND user_id = $2`, passkeyID, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete passkey"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Passkey not found"})
+		return
+	}
+
+	recordSecurityEvent(c, userId, models.EventPasskeyRemoved, "", gin.H{"passkeyId": passkeyID})
+	c.JSON(http.StatusOK, gin.H{"message": "Passkey deleted"})
+}
+
+// BeginPasskeyLogin returns the options for navigator.credentials.get(). No username is
+// needed: passkeys are discoverable, and the authenticator tells us whose passkey it used.
+func BeginPasskeyLogin(c *gin.Context) {
+	challengeID, challenge, err := newPasskeyChallenge(0, ceremonyLogin)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey login"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"challengeId": challengeID,
+		"publicKey": gin.H{
+			"rpId":             webauthn.ConfigFromEnv().RPID,
+			"challenge":        challenge,
+			"timeout":          passkeyChallengeTTL.Milliseconds(),
+			"userVerification": "required",
+		},
+	})
+}
+
+// FinishPasskeyLogin verifies a passkey assertion and signs the user in like LoginUser.
+// A passkey already proves possession and user verification (PIN or biometrics),
+// so no TOTP code is asked for on top.
+func FinishPasskeyLogin(c *gin.Context) {
+	var input models.PasskeyLoginInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Step 1: Redeem the login challenge
+	challenge, err := consumePasskeyChallenge(input.ChallengeID, 0, ceremonyLogin)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired passkey challenge"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	response := input.Credential.Response
+	clientDataJSON, err := webauthn.DecodeBase64URL(response.ClientDataJSON)
+	var authenticatorData, signature []byte
+	if err == nil {
+		authenticatorData, err = webauthn.DecodeBase64URL(response.AuthenticatorData)
+	}
+	if err == nil {
+		signature, err = webauthn.DecodeBase64URL(response.Signature)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid credential encoding"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Step 2: Find the passkey; the row is locked so concurrent logins see each other's counter
+	var passkeyID, userID int
+	var publicKey []byte
+	var signCount int64
+	err = tx.QueryRow(`
+	SELECT id, user_id, public_key, sign_count FROM passkeys WHERE credential_id = $1 FOR UPDATE
+`, input.Credential.ID).Scan(&passkeyID, &userID, &publicKey, &signCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unknown passkey"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if response.UserHandle != "" && response.UserHandle != passkeyUserHandle(userID) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Passkey could not be verified"})
+		return
+	}
+
+	// Step 3: Verify the signature and the signature counter
+	newSignCount, err := webauthn.ConfigFromEnv().VerifyAssertion(challenge, publicKey, uint32(signCount),
+		clientDataJSON, authenticatorData, signature)
+	if err != nil {
+		log.Printf("Passkey login failed for passkey %d: %v", passkeyID, err)
+		recordSecurityEvent(c, userID, models.EventLoginFailed, "", gin.H{"method": "passkey", "reason": "invalid_assertion", "passkeyId": passkeyID})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Passkey could not be verified"})
+		return
+	}
+
+	_, err = tx.Exec(`UPDATE passkeys SET sign_count = $1, last_used_at = NOW() WHERE id = $2`, int64(newSignCount), passkeyID)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Step 4: Issue the same tokens as a password login
+	user, err := findUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	completeLogin(c, user, input.DeviceName, "passkey")
+}
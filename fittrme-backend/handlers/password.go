@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fittrme-backend/database"
+	"fittrme-backend/mailer"
+	"fittrme-backend/models"
+	"fittrme-backend/utils"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limits on password reset emails: per account, and per IP address across all accounts
+// (counted whether or not the email is registered).
+var (
+	passwordResetLimit   = sendLimit{interval: time.Minute, perDay: 5}
+	passwordResetIPLimit = sendLimit{interval: 0, perDay: 20}
+)
+
+// passwordResetTTL reads the reset token lifetime from PASSWORD_RESET_TTL_MIN (default 30 minutes).
+func passwordResetTTL() time.Duration {
+	ttlMin, _ := strconv.Atoi(os.Getenv("PASSWORD_RESET_TTL_MIN"))
+	if ttlMin == 0 {
+		ttlMin = 30
+	}
+	return time.Minute * time.Duration(ttlMin)
+}
+
+// rejectWeakPassword checks a newly chosen password against the password policy
+// (see utils.CheckPassword). If it fails, it responds 400 with the reasons under
+// the name of the request field and returns true.
+func rejectWeakPassword(c *gin.Context, field, password, username, email string) bool {
+	reasons := utils.CheckPassword(password, username, email)
+	if len(reasons) == 0 {
+		return false
+	}
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error":  "Password does not meet the requirements",
+		"fields": gin.H{field: reasons},
+	})
+	return true
+}
+
+// ForgotPassword emails a single-use password reset token to the account with the given email.
+// It always answers with the same message so it cannot be used to find out which emails are registered.
+func ForgotPassword(c *gin.Context) {
+	var input models.ForgotPasswordInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	email := normalizeEmail(input.Email)
+
+	// Step 1: Limit requests per IP address, whether or not the email is registered
+	wait, err := sendWait(passwordResetIPLimit, `
+	SELECT created_at FROM security_events WHERE ip_address = $1 AND event_type = $2 ORDER BY id DESC
+`, c.ClientIP(), models.EventPasswordResetRequested)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many password reset requests, please try again later"})
+		return
+	}
+
+	// Step 2: Look up the account; unknown emails get the same response as known ones
+	var userID int
+	err = database.DB.QueryRow(`SELECT user_id FROM users WHERE email = $1`, email).Scan(&userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	recordSecurityEvent(c, userID, models.EventPasswordResetRequested, "", nil)
+
+	// Step 3: Issue and email the link in the background, so the response time does not
+	// reveal whether the account exists. Past the per-account limit nothing is sent, with
+	// the same response, so the link cannot be used to flood an inbox.
+	if userID != 0 {
+		go sendPasswordReset(userID, email)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If that email is registered, a password reset link has been sent"})
+}
+
+// sendPasswordReset issues a reset token and emails it, unless userID is past
+// passwordResetLimit. It runs after ForgotPassword has responded, so failures are logged.
+func sendPasswordReset(userID int, email string) {
+	wait, err := sendWait(passwordResetLimit, `
+	SELECT created_at FROM password_reset_tokens WHERE user_id = $1 ORDER BY created_at DESC
+`, userID)
+	if err != nil {
+		log.Println("Failed to check password reset limit:", err)
+		return
+	}
+	if wait > 0 {
+		return
+	}
+
+	rawToken, err := createPasswordResetToken(userID)
+	if err == nil {
+		err = sendPasswordResetEmail(email, rawToken)
+	}
+	if err != nil {
+		log.Println("Failed to send password reset email:", err)
+	}
+}
+
+// createPasswordResetToken issues a new reset token for userID, invalidating any
+// earlier unused ones, and returns the raw token. Only its hash is stored.
+func createPasswordResetToken(userID int) (string, error) {
+	rawToken, tokenHash, err := utils.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE password_reset_tokens SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL`, userID)
+	if err == nil {
+		_, err = tx.Exec(`
+	INSERT INTO password_reset_tokens (user_id, token_hash, expires_at)
+	VALUES ($1, $2, $3)
+`, userID, tokenHash, time.Now().Add(passwordResetTTL()))
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	return rawToken, err
+}
+
+// sendPasswordResetEmail emails the link for a token from createPasswordResetToken.
+func sendPasswordResetEmail(email, rawToken string) error {
+	return mailer.Default.Send(mailer.Message{
+		To:      email,
+		Subject: "Reset your FittrMe password",
+		Body: fmt.Sprintf("Someone asked to reset the password for your FittrMe account.\n\n"+
+			"Use this link within %d minutes to choose a new password:\n%s\n\n"+
+			"If you didn't ask for this, you can ignore this email.",
+			int(passwordResetTTL().Minutes()), appLink("PASSWORD_RESET_URL", "fittrme://reset-password", rawToken)),
+	})
+}
+
+// ResetPassword sets a new password using a token from ForgotPassword.
+// The token can only be used once, and every session of the user is signed out.
+func ResetPassword(c *gin.Context) {
+	var input models.ResetPasswordInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Step 1: Find an unused, unexpired token with this hash, locking it against concurrent use
+	var tokenID, userID int
+	err = tx.QueryRow(`
+	SELECT id, user_id FROM password_reset_tokens
+	WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+	FOR UPDATE
+`, utils.HashToken(input.Token)).Scan(&tokenID, &userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// The new password has to pass the policy; the token stays usable if it doesn't
+	var username, email string
+	if err := tx.QueryRow(`SELECT username, email FROM users WHERE user_id = $1`, userID).Scan(&username, &email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if rejectWeakPassword(c, "newPassword", input.NewPassword, username, email) {
+		return
+	}
+
+	hashedPassword, err := utils.HashPassword(input.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	// Step 2: Consume the token, store the new password and sign out every session
+	_, err = tx.Exec(`UPDATE password_reset_tokens SET used_at = NOW() WHERE id = $1`, tokenID)
+	if err == nil {
+		// The reset link was emailed, so following it also proves the user owns the address
+		_, err = tx.Exec(`
+	UPDATE users SET password_hash = $1, email_verified_at = COALESCE(email_verified_at, NOW()),
+		password_reset_required_at = NULL
+	WHERE user_id = $2
+`, hashedPassword, userID)
+	}
+	if err == nil {
+		err = revokeOtherSessions(tx, userID, "")
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	recordSecurityEvent(c, userID, models.EventPasswordReset, "", nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset, please log in again"})
+}
+
+// appLink builds a link to the app for an emailed token. The base URL comes from
+// the named environment variable, falling back to the given deep link.
+func appLink(envKey, fallback, token string) string {
+	base := os.Getenv(envKey)
+	if base == "" {
+		base = fallback
+	}
+	return base + "?token=" + token
+}
+
+// linkConfirmation is the page an emailed link that changes something opens. The change
+// is only made when its form is posted back, so mail scanners and link previews that
+// fetch every link in an email cannot make it on their own.
+type linkConfirmation struct {
+	Title   string
+	Heading string
+	Text    string
+	Button  string
+	Token   string
+}
+
+var linkConfirmationPage = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width"><title>{{.Title}} - FittrMe</title></head>
+<body>
+<h1>{{.Heading}}</h1>
+<p>{{.Text}}</p>
+<form method="post">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">{{.Button}}</button>
+</form>
+</body>
+</html>
+`))
+
+// renderLinkConfirmation responds with the confirmation page of an emailed link.
+func renderLinkConfirmation(c *gin.Context, page linkConfirmation) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := linkConfirmationPage.Execute(c.Writer, page); err != nil {
+		log.Println("Failed to render link confirmation page:", err)
+	}
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fittrme-backend/database"
+	"fittrme-backend/models"
+	"fittrme-backend/utils"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// GetProfile returns the logged-in user's account and profile.
+func GetProfile(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+
+	user, err := findUserByID(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	roles, err := userRoles(database.DB, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user": user, "roles": roles})
+}
+
+// UpdateProfile changes the profile fields present in the request.
+func UpdateProfile(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+
+	var input models.UpdateProfileInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Step 1: Checks the binding tags cannot express
+	if input.Username != nil {
+		username, ok := normalizeUsername(c, *input.Username)
+		if !ok {
+			return
+		}
+		input.Username = &username
+
+		taken, err := usernameTaken(database.DB, username, userId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if taken {
+			c.JSON(http.StatusConflict, gin.H{"error": "Username is already taken"})
+			return
+		}
+	}
+	if input.DateOfBirth != nil {
+		dob, _ := time.Parse("2006-01-02", *input.DateOfBirth)
+		if dob.After(time.Now()) || dob.Year() < 1900 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date of birth"})
+			return
+		}
+	}
+
+	// Step 2: Build the SET list from the fields that were sent
+	var sets []string
+	var args []interface{}
+	for _, f := range []struct {
+		column string
+		value  *string
+	}{
+		{"username", input.Username},
+		{"display_name", input.DisplayName},
+		{"date_of_birth", input.DateOfBirth},
+		{"sex", input.Sex},
+		{"activity_level", input.ActivityLevel},
+		{"timezone", input.Timezone},
+		{"locale", input.Locale},
+		{"units", input.Units},
+	} {
+		if f.value != nil {
+			args = append(args, *f.value)
+			sets = append(sets, fmt.Sprintf("%s = $%d", f.column, len(args)))
+		}
+	}
+	if len(sets) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No profile fields to update"})
+		return
+	}
+
+	// Step 3: Update and return the new profile
+	args = append(args, userId)
+	user, err := scanUser(database.DB.QueryRow(`
+	UPDATE users SET `+strings.Join(sets, ", ")+`
+	WHERE user_id = $`+fmt.Sprint(len(args))+`
+	RETURNING `+userColumns, args...))
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		// Lost a race with another user taking the same username
+		c.JSON(http.StatusConflict, gin.H{"error": "Username is already taken"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Profile updated", "user": user})
+}
+
+// verifyCurrentPassword re-authenticates the logged-in user before a sensitive change.
+// Attempts are throttled like logins so a stolen access token cannot be used to guess
+// the password. It writes the error response itself and returns false on failure.
+func verifyCurrentPassword(c *gin.Context, user models.User, password, failureMessage string) bool {
+	accountKey := accountThrottleKey(user.UserID, "")
+	wait, err := loginRetryAfter(accountKey, c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return false
+	}
+	if wait > 0 {
+		respondTooManyAttempts(c, wait)
+		return false
+	}
+	if ok, _, _ := utils.VerifyPassword(user.PasswordHash, password); !ok {
+		if err := recordLoginFailure(accountKey, user.UserID, c.ClientIP()); err != nil {
+			log.Println("Failed to record login failure:", err)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": failureMessage})
+		return false
+	}
+	return true
+}
+
+// recentSignInWindow reads from REAUTH_WINDOW_MIN how recently an account without a
+// password must have signed in to make a sensitive change (default 10 minutes).
+func recentSignInWindow() time.Duration {
+	minutes, _ := strconv.Atoi(os.Getenv("REAUTH_WINDOW_MIN"))
+	if minutes == 0 {
+		minutes = 10
+	}
+	return time.Minute * time.Duration(minutes)
+}
+
+// reauthenticate confirms it is really the account owner before a sensitive change,
+// so a stolen access token alone is not enough. Accounts with a password re-enter it.
+// Accounts without one (OIDC or passkey only) must have signed in within
+// recentSignInWindow: every sign-in, with a passkey or the identity provider, starts a
+// new session, so the client signs in again and retries with the fresh tokens.
+// It writes the error response itself and returns false on failure.
+func reauthenticate(c *gin.Context, user models.User, password, failureMessage string) bool {
+	if user.PasswordHash != "" {
+		return verifyCurrentPassword(c, user, password, failureMessage)
+	}
+
+	var signedInAt time.Time
+	err := database.DB.QueryRow(`
+	SELECT created_at FROM sessions WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+`, c.GetString("sessionId"), user.UserID).Scan(&signedInAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return false
+	}
+	if err != nil || time.Since(signedInAt) > recentSignInWindow() {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":          "Please sign in again to confirm it's you",
+			"reauthRequired": true,
+		})
+		return false
+	}
+	return true
+}
+
+// ChangePassword sets a new password after checking the current one. Every other
+// session is signed out; the one making the request stays signed in.
+func ChangePassword(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+
+	var input models.ChangePasswordInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := findUserByID(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Step 1: Verify the current password
+	if !verifyCurrentPassword(c, user, input.CurrentPassword, "Current password is incorrect") {
+		return
+	}
+
+	// Step 2: The new password has to pass the policy
+	if rejectWeakPassword(c, "newPassword", input.NewPassword, user.Username, user.Email) {
+		return
+	}
+	hashedPassword, err := utils.HashPassword(input.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	// Step 3: Store it, drop pending reset links and sign out the other sessions
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE users SET password_hash = $1 WHERE user_id = $2`, hashedPassword, userId)
+	if err == nil {
+		_, err = tx.Exec(`
+	UPDATE password_reset_tokens SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL
+`, userId)
+	}
+	if err == nil {
+		err = revokeOtherSessions(tx, userId, extractSessionID(c))
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+
+	recordSecurityEvent(c, userId, models.EventPasswordChanged, "", nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed"})
+}
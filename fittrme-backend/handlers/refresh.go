@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fittrme-backend/database"
+	"fittrme-backend/models"
+	"fittrme-backend/utils"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RefreshToken exchanges a valid refresh token for a new access/refresh pair.
+// The presented token is revoked and its replacement joins the same family,
+// which is also the session the device signed in with.
+// If a token that was already rotated is presented again, the whole family is
+// revoked, since either the client or an attacker holds a stolen copy.
+func RefreshToken(c *gin.Context) {
+	var input models.RefreshInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Step 1: Look up the stored token by hash, locking the row so two
+	// concurrent refreshes with the same token cannot both succeed.
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var stored models.RefreshToken
+	var sessionRevoked bool
+	err = tx.QueryRow(`
+	SELECT rt.id, rt.user_id, rt.family_id, rt.expires_at, rt.revoked, s.revoked_at IS NOT NULL
+	FROM refresh_tokens rt
+	JOIN sessions s ON s.id = rt.family_id
+	WHERE rt.token_hash = $1
+	FOR UPDATE OF rt
+`, utils.HashToken(input.RefreshToken)).Scan(
+		&stored.ID,
+		&stored.UserID,
+		&stored.FamilyID,
+		&stored.ExpiresAt,
+		&stored.Revoked,
+		&sessionRevoked,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Step 2: A session signed out via logout or the session list can no longer refresh.
+	if sessionRevoked {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked, please log in again"})
+		return
+	}
+
+	// Step 3: Reuse detection. A revoked token means it was already rotated,
+	// so revoke the whole session (token family) and force a fresh login.
+	if stored.Revoked {
+		err = revokeSession(tx, stored.FamilyID)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		recordSecurityEvent(c, stored.UserID, models.EventTokenReuseDetected, stored.FamilyID, nil)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected, please log in again"})
+		return
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token expired"})
+		return
+	}
+
+	// Step 4: Rotate: revoke the presented token, record the session as used
+	// and issue the replacement token in the same family.
+	_, err = tx.Exec(`UPDATE refresh_tokens SET revoked = TRUE WHERE id = $1`, stored.ID)
+	if err == nil {
+		_, err = tx.Exec(`
+	UPDATE sessions SET last_used_at = NOW(), user_agent = $2, ip_address = $3
+	WHERE id = $1
+`, stored.FamilyID, c.Request.UserAgent(), c.ClientIP())
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate refresh token"})
+		return
+	}
+
+	tokens, err := issueTokenPair(tx, stored.UserID, stored.FamilyID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate tokens"})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate refresh token"})
+		return
+	}
+
+	recordSecurityEvent(c, stored.UserID, models.EventTokenRefreshed, stored.FamilyID, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Token refreshed",
+		"accessToken":  tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+	})
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fittrme-backend/database"
+	"fittrme-backend/models"
+	"fittrme-backend/revocation"
+	"fittrme-backend/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// createSession records a new signed-in device for userID and returns its ID.
+// The ID doubles as the refresh token family for that device.
+func createSession(db dbExecutor, c *gin.Context, userID int, deviceName string) (string, error) {
+	sessionID, err := utils.NewTokenFamilyID()
+	if err != nil {
+		return "", err
+	}
+	if deviceName == "" {
+		deviceName = "Unknown device"
+	}
+
+	_, err = db.Exec(`
+	INSERT INTO sessions (id, user_id, device_name, user_agent, ip_address)
+	VALUES ($1, $2, $3, $4, $5)
+`, sessionID, userID, deviceName, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		return "", err
+	}
+	return sessionID, nil
+}
+
+// revokeSession marks a session as revoked and revokes every refresh token in it,
+// along with any access token already issued for it.
+func revokeSession(db dbExecutor, sessionID string) error {
+	if _, err := db.Exec(`UPDATE sessions SET revoked_at = COALESCE(revoked_at, NOW()) WHERE id = $1`, sessionID); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`UPDATE refresh_tokens SET revoked = TRUE WHERE family_id = $1`, sessionID); err != nil {
+		return err
+	}
+	return revocation.RevokeSession(db, sessionID)
+}
+
+// extractSessionID returns the session ID AuthRequired took from the access token.
+func extractSessionID(c *gin.Context) string {
+	sessionID, _ := c.Get("sessionId")
+	s, _ := sessionID.(string)
+	return s
+}
+
+// ListSessions returns every active (non-revoked) session of the logged-in user.
+func ListSessions(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+
+	rows, err := database.DB.Query(`
+	SELECT id, user_id, device_name, user_agent, ip_address, created_at, last_used_at
+	FROM sessions
+	WHERE user_id = $1 AND revoked_at IS NULL
+	ORDER BY last_used_at DESC
+`, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	currentSessionID := extractSessionID(c)
+	sessions := []models.Session{}
+	for rows.Next() {
+		var s models.Session
+		if err := rows.Scan(&s.ID, &s.UserID, &s.DeviceName, &s.UserAgent, &s.IPAddress, &s.CreatedAt, &s.LastUsedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		s.Current = s.ID == currentSessionID
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// RevokeSession signs out a single session (device) of the logged-in user.
+func RevokeSession(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+	sessionID := c.Param("id")
+
+	// Only allow revoking sessions that belong to the caller.
+	var owner int
+	err := database.DB.QueryRow(`SELECT user_id FROM sessions WHERE id = $1 AND revoked_at IS NULL`, sessionID).Scan(&owner)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && owner != userId) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := revokeSession(database.DB, sessionID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	recordSecurityEvent(c, userId, models.EventSessionRevoked, "", gin.H{"revokedSessionId": sessionID})
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+// RevokeOtherSessions signs out every session of the logged-in user except the
+// one making the request ("log out everywhere else").
+func RevokeOtherSessions(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	err = revokeOtherSessions(tx, userId, extractSessionID(c))
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	recordSecurityEvent(c, userId, models.EventOtherSessionsRevoked, "", nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Signed out of all other sessions"})
+}
+
+// revokeOtherSessions revokes every active session of userID except keepSessionID.
+// Pass an empty keepSessionID to revoke all of them.
+func revokeOtherSessions(tx *sql.Tx, userID int, keepSessionID string) error {
+	rows, err := tx.Query(`
+	SELECT id FROM sessions
+	WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL
+`, userID, keepSessionID)
+	if err != nil {
+		return err
+	}
+	var sessionIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		sessionIDs = append(sessionIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range sessionIDs {
+		if err := revokeSession(tx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fittrme-backend/database"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Login throttling. Failed logins are counted per account and per client IP.
+// Past a threshold, each further failure doubles how long the key must wait before
+// the next attempt; every accountLockoutEvery failures on an account also locks it
+// for lockoutDuration and records an account_lockouts row for support. Unknown
+// usernames are locked on the same schedule (without the record), so the wait does
+// not reveal whether an account exists.
+const (
+	accountBackoffAfter = 3
+	accountLockoutEvery = 10
+	ipBackoffAfter      = 20 // higher, since many users can share one IP (NAT, mobile carriers)
+	maxBackoff          = 15 * time.Minute
+	lockoutDuration     = 15 * time.Minute
+	// failureWindow is how long a key's failures are remembered after the last one.
+	failureWindow = 24 * time.Hour
+)
+
+// invalidCredentialsError is the single error for an unknown username and a wrong password,
+// so the response does not reveal which usernames exist.
+const invalidCredentialsError = "Invalid username or password"
+
+// accountThrottleKey is the throttle key for a login attempt. Unknown usernames are
+// throttled by name (ignoring case, like usernames) so they behave exactly like existing accounts.
+func accountThrottleKey(userID int, username string) string {
+	if userID != 0 {
+		return "user:" + strconv.Itoa(userID)
+	}
+	return "name:" + strings.ToLower(username)
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// backoff returns how long a key with the given failure count must wait after its last failure.
+func backoff(failures, threshold int) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+	exp := failures - threshold
+	if exp > 20 {
+		return maxBackoff
+	}
+	d := time.Duration(math.Pow(2, float64(exp))) * time.Second
+	if d > maxBackoff {
+		return maxBackoff
+	}
+	return d
+}
+
+// loginRetryAfter returns how long the caller must wait before trying to log in
+// to this account from this IP again, or 0 if the attempt may go ahead.
+func loginRetryAfter(accountKey, ip string) (time.Duration, error) {
+	var wait time.Duration
+	for _, k := range []struct {
+		key       string
+		threshold int
+	}{
+		{accountKey, accountBackoffAfter},
+		{ipThrottleKey(ip), ipBackoffAfter},
+	} {
+		var failures int
+		var lastFailure time.Time
+		var lockedUntil sql.NullTime
+		err := database.DB.QueryRow(`
+	SELECT failures, last_failure_at, locked_until FROM login_throttles WHERE throttle_key = $1
+`, k.key).Scan(&failures, &lastFailure, &lockedUntil)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		if time.Since(lastFailure) > failureWindow {
+			continue
+		}
+
+		if d := time.Until(lastFailure.Add(backoff(failures, k.threshold))); d > wait {
+			wait = d
+		}
+		if lockedUntil.Valid {
+			if d := time.Until(lockedUntil.Time); d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait, nil
+}
+
+// recordLoginFailure counts a failed attempt against the account and the IP.
+// userID is 0 when the username does not exist.
+func recordLoginFailure(accountKey string, userID int, ip string) error {
+	for _, key := range []string{accountKey, ipThrottleKey(ip)} {
+		var failures int
+		err := database.DB.QueryRow(`
+	INSERT INTO login_throttles (throttle_key, failures, last_failure_at)
+	VALUES ($1, 1, NOW())
+	ON CONFLICT (throttle_key) DO UPDATE SET
+		failures = CASE
+			WHEN login_throttles.last_failure_at < NOW() - $2::INTERVAL THEN 1
+			ELSE login_throttles.failures + 1
+		END,
+		last_failure_at = NOW()
+	RETURNING failures
+`, key, fmt.Sprintf("%d seconds", int(failureWindow.Seconds()))).Scan(&failures)
+		if err != nil {
+			return err
+		}
+
+		// Lock the account and, for a real one, leave a record support can act on
+		if key == accountKey && failures%accountLockoutEvery == 0 {
+			lockedUntil := time.Now().Add(lockoutDuration)
+			_, err = database.DB.Exec(`UPDATE login_throttles SET locked_until = $1 WHERE throttle_key = $2`, lockedUntil, key)
+			if err == nil && userID != 0 {
+				_, err = database.DB.Exec(`
+	INSERT INTO account_lockouts (user_id, ip_address, failures, locked_until)
+	VALUES ($1, $2, $3, $4)
+`, userID, ip, failures, lockedUntil)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// clearLoginFailures forgets an account's failed attempts after a successful login.
+// IP counters are left alone, so one good login cannot reset a spraying attacker.
+func clearLoginFailures(userID int) error {
+	_, err := database.DB.Exec(`DELETE FROM login_throttles WHERE throttle_key = $1`, accountThrottleKey(userID, ""))
+	return err
+}
+
+// respondTooManyAttempts answers a throttled login attempt with 429 and Retry-After.
+func respondTooManyAttempts(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts, please try again later"})
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"database/sql"
+	"fittrme-backend/utils"
+)
+
+// dbExecutor is satisfied by both *sql.DB and *sql.Tx, so token issuance
+// can run on its own or as part of a caller's transaction.
+type dbExecutor interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// tokenPair is the access/refresh token pair handed to the client after
+// a successful login or refresh.
+type tokenPair struct {
+	AccessToken  string
+	RefreshToken string
+}
+
+// issueTokenPair signs a new access token for userID and stores the hash of a
+// new refresh token in the given session (its refresh token family).
+func issueTokenPair(db dbExecutor, userID int, sessionID string) (tokenPair, error) {
+	// Look up the user's roles; they are embedded in the access token.
+	roles, err := userRoles(db, userID)
+	if err != nil {
+		return tokenPair{}, err
+	}
+
+	// Generate a short-lived access token (JWT) for this user.
+	accessToken, err := utils.GenerateAccessToken(userID, sessionID, roles)
+	if err != nil {
+		return tokenPair{}, err
+	}
+
+	// Create a long-lived refresh token: the raw value goes to the client,
+	// only its hash is persisted so we can validate future refresh requests.
+	rawRefreshToken, refreshTokenHash, refreshTokenExp, err := utils.NewRefreshToken()
+	if err != nil {
+		return tokenPair{}, err
+	}
+
+	_, err = db.Exec(`
+	INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at)
+	VALUES ($1, $2, $3, $4)
+`, userID, sessionID, refreshTokenHash, refreshTokenExp)
+	if err != nil {
+		return tokenPair{}, err
+	}
+
+	return tokenPair{AccessToken: accessToken, RefreshToken: rawRefreshToken}, nil
+}
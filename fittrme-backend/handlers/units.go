@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"fittrme-backend/database"
+	"fittrme-backend/models"
+	"fittrme-backend/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Unit systems at the API boundary. Body values are stored in kg and cm (percentages
+// as they are). Requests may send lb and in instead, and responses are converted to
+// the unit system the client asks for with ?units= or an Accept-Units header, falling
+// back to the user's preference (users.units).
+//
+// Rounding: stored values have two decimals, like the NUMERIC(6,2) columns, and so do
+// metric responses. Imperial responses have one decimal, which is all the precision
+// the stored value carries (0.01 kg is 0.02 lb), so a value entered in lb or in reads
+// back as entered.
+const (
+	storedDecimals   = 2
+	imperialDecimals = 1
+)
+
+// unitSystem returns the unit system of the response: the units query parameter or
+// Accept-Units header if the request has one, otherwise preference. It writes the
+// error response itself and returns false if the requested system is unknown.
+func unitSystem(c *gin.Context, preference string) (string, bool) {
+	system := c.Query("units")
+	if system == "" {
+		system = c.GetHeader("Accept-Units")
+	}
+	if system == "" {
+		system = preference
+	}
+	switch system {
+	case models.UnitsMetric, models.UnitsImperial:
+		return system, true
+	case "":
+		return models.UnitsMetric, true
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid units, expected metric or imperial"})
+	return "", false
+}
+
+// userUnitSystem is unitSystem with the preference of the given user.
+func userUnitSystem(c *gin.Context, userID int) (string, bool) {
+	if c.Query("units") == "" && c.GetHeader("Accept-Units") == "" {
+		var preference string
+		if err := database.DB.QueryRow(`SELECT units FROM users WHERE user_id = $1`, userID).Scan(&preference); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return "", false
+		}
+		return unitSystem(c, preference)
+	}
+	return unitSystem(c, "")
+}
+
+// toStoredUnit converts a value sent in unit to the unit it is stored in: lb to kg and
+// in to cm, while kg, cm and percent stay. ok is false for an unknown unit.
+func toStoredUnit(value float64, unit string) (float64, string, bool) {
+	switch unit {
+	case models.UnitLb:
+		return utils.RoundTo(utils.LbToKg(value), storedDecimals), models.UnitKg, true
+	case models.UnitInch:
+		return utils.RoundTo(utils.InchToCm(value), storedDecimals), models.UnitCm, true
+	case models.UnitKg, models.UnitCm, models.UnitPercent:
+		return utils.RoundTo(value, storedDecimals), unit, true
+	}
+	return 0, "", false
+}
+
+// fromStoredUnit converts a value stored in storedUnit to the given unit system and
+// returns it with the unit it is now in.
+func fromStoredUnit(value float64, storedUnit, system string) (float64, string) {
+	if system == models.UnitsImperial {
+		switch storedUnit {
+		case models.UnitKg:
+			return utils.RoundTo(utils.KgToLb(value), imperialDecimals), models.UnitLb
+		case models.UnitCm:
+			return utils.RoundTo(utils.CmToInch(value), imperialDecimals), models.UnitInch
+		}
+	}
+	return utils.RoundTo(value, storedDecimals), storedUnit
+}
+
+// storedWeight converts a weight or height sent in unit (storedUnit if empty) to storedUnit.
+// The binding of the weight payloads only allows units that convert to storedUnit.
+func storedWeight(value float64, unit, storedUnit string) float64 {
+	if unit == "" {
+		unit = storedUnit
+	}
+	value, _, _ = toStoredUnit(value, unit)
+	return value
+}
+
+// weightInUnits converts a weigh-in as stored (kg and cm) to the given unit system.
+func weightInUnits(w models.Weight, system string) models.Weight {
+	w.CurrentWeight, w.Unit = fromStoredUnit(w.CurrentWeight, models.UnitKg, system)
+	w.TargetWeight, _ = fromStoredUnit(w.TargetWeight, models.UnitKg, system)
+	w.Height, w.HeightUnit = fromStoredUnit(w.Height, models.UnitCm, system)
+	return w
+}
+
+// measurementInUnits converts a reading as stored to the given unit system.
+func measurementInUnits(m models.Measurement, system string) models.Measurement {
+	m.Value, m.Unit = fromStoredUnit(m.Value, m.Unit, system)
+	return m
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"database/sql"
+	"fittrme-backend/database"
+	"fittrme-backend/models"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Limits on the length of a username, in characters.
+const (
+	usernameMinLength = 3
+	usernameMaxLength = 30
+)
+
+// userColumns is the column list scanUser expects, in order.
+const userColumns = `user_id, username, email, password_hash, created_at, email_verified_at, suspended_at,
+	display_name, TO_CHAR(date_of_birth, 'YYYY-MM-DD') AS date_of_birth, sex, activity_level, timezone, locale, units, delete_after,
+	password_reset_required_at`
+
+// scanUser scans a row selected with userColumns into a models.User.
+func scanUser(row *sql.Row) (models.User, error) {
+	var user models.User
+	err := row.Scan(
+		&user.UserID,
+		&user.Username,
+		&user.Email,
+		&user.PasswordHash,
+		&user.CreatedAt,
+		&user.EmailVerifiedAt,
+		&user.SuspendedAt,
+		&user.DisplayName,
+		&user.DateOfBirth,
+		&user.Sex,
+		&user.ActivityLevel,
+		&user.Timezone,
+		&user.Locale,
+		&user.Units,
+		&user.DeleteAfter,
+		&user.PasswordResetRequiredAt,
+	)
+	return user, err
+}
+
+// normalizeEmail trims an email address and lower-cases it, since addresses are
+// treated as case-insensitive everywhere (signup, login by email, email change).
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// normalizeUsername trims a username chosen at signup or in the profile and checks its
+// length. Usernames keep the case they were chosen with but are unique regardless of it
+// (the users_username_lower_key index), so "Sam" and "sam" cannot both exist and either
+// logs in as the same account. It writes the error response itself and returns false if
+// the username is invalid.
+func normalizeUsername(c *gin.Context, username string) (string, bool) {
+	username = strings.TrimSpace(username)
+	if n := utf8.RuneCountInString(username); n < usernameMinLength || n > usernameMaxLength {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Username must be between %d and %d characters long", usernameMinLength, usernameMaxLength),
+		})
+		return "", false
+	}
+	return username, true
+}
+
+// usernameTaken reports whether a user other than userID has the username, ignoring case.
+func usernameTaken(db dbExecutor, username string, userID int) (bool, error) {
+	var taken bool
+	err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(username) = LOWER($1) AND user_id <> $2)`, username, userID).Scan(&taken)
+	return taken, err
+}
+
+// findUserByID loads a user by primary key; it returns sql.ErrNoRows if there is none.
+func findUserByID(userID int) (models.User, error) {
+	return scanUser(database.DB.QueryRow(`SELECT `+userColumns+` FROM users WHERE user_id = $1`, userID))
+}
+
+// userRoles returns the roles a user holds, sorted by name.
+func userRoles(db dbExecutor, userID int) ([]string, error) {
+	var roles []string
+	err := db.QueryRow(`
+	SELECT COALESCE(array_agg(role ORDER BY role), '{}') FROM user_roles WHERE user_id = $1
+`, userID).Scan(pq.Array(&roles))
+	return roles, err
+}
+
+// createUser inserts a new account together with the default member role.
+// passwordHash is empty for accounts created through an external identity
+// provider; such accounts can only sign in with a password after a reset.
+func createUser(db dbExecutor, username, email, passwordHash string, emailVerified bool) (models.User, error) {
+	return scanUser(db.QueryRow(`
+	WITH new_user AS (
+		INSERT INTO users (username, email, password_hash, email_verified_at)
+		VALUES ($1, $2, $3, CASE WHEN $4 THEN NOW() END)
+		RETURNING `+userColumns+`
+	), member_role AS (
+		INSERT INTO user_roles (user_id, role)
+		SELECT user_id, $5 FROM new_user
+	)
+	SELECT * FROM new_user
+`, username, email, passwordHash, emailVerified, models.RoleMember))
+}
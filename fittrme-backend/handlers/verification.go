@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fittrme-backend/database"
+	"fittrme-backend/mailer"
+	"fittrme-backend/models"
+	"fittrme-backend/utils"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Email verification policies, selected with EMAIL_VERIFICATION_POLICY.
+const (
+	// VerificationPolicyBlock refuses to log in unverified accounts.
+	VerificationPolicyBlock = "block"
+	// VerificationPolicyLimit lets unverified accounts log in, but routes behind
+	// middleware.RequireVerifiedEmail stay closed until the email is verified.
+	VerificationPolicyLimit = "limit"
+)
+
+// sendLimit caps how often an emailed link goes out: at most one per interval and
+// perDay in any 24 hours.
+type sendLimit struct {
+	interval time.Duration
+	perDay   int
+}
+
+// verificationLimit applies to verification emails, per account.
+var verificationLimit = sendLimit{interval: time.Minute, perDay: 5}
+
+// sendWait returns how long to wait before another link may be sent under limit, or 0
+// if one may be sent now. query selects the send times of the earlier links, newest
+// first, given args; only the last day of them matters.
+func sendWait(limit sendLimit, query string, args ...interface{}) (time.Duration, error) {
+	rows, err := database.DB.Query(query+` LIMIT `+strconv.Itoa(limit.perDay), args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var sent []time.Time
+	for rows.Next() {
+		var t time.Time
+		if err := rows.Scan(&t); err != nil {
+			return 0, err
+		}
+		sent = append(sent, t)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var wait time.Duration
+	if len(sent) > 0 {
+		wait = limit.interval - time.Since(sent[0])
+	}
+	if len(sent) == limit.perDay {
+		// The oldest of the last perDay links has to leave the 24 hours
+		wait = max(wait, 24*time.Hour-time.Since(sent[len(sent)-1]))
+	}
+	return max(wait, 0), nil
+}
+
+// emailVerificationPolicy returns the configured policy, defaulting to VerificationPolicyLimit.
+func emailVerificationPolicy() string {
+	if os.Getenv("EMAIL_VERIFICATION_POLICY") == VerificationPolicyBlock {
+		return VerificationPolicyBlock
+	}
+	return VerificationPolicyLimit
+}
+
+// emailVerificationTTL reads the verification link lifetime from EMAIL_VERIFICATION_TTL_HOURS (default 24 hours).
+func emailVerificationTTL() time.Duration {
+	hours, _ := strconv.Atoi(os.Getenv("EMAIL_VERIFICATION_TTL_HOURS"))
+	if hours == 0 {
+		hours = 24
+	}
+	return time.Hour * time.Duration(hours)
+}
+
+// sendVerificationEmail stores a new verification token for email and mails the link to it.
+// The token is tied to the address so it stops working if the email is changed before it is used.
+func sendVerificationEmail(userID int, email string) error {
+	rawToken, tokenHash, err := utils.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+	ttl := emailVerificationTTL()
+
+	_, err = database.DB.Exec(`
+	INSERT INTO email_verification_tokens (user_id, email, token_hash, expires_at)
+	VALUES ($1, $2, $3, $4)
+`, userID, email, tokenHash, time.Now().Add(ttl))
+	if err != nil {
+		return err
+	}
+
+	return mailer.Default.Send(mailer.Message{
+		To:      email,
+		Subject: "Verify your FittrMe email address",
+		Body: fmt.Sprintf("Welcome to FittrMe!\n\n"+
+			"Please confirm your email address within %d hours by opening this link:\n%s",
+			int(ttl.Hours()), appLink("EMAIL_VERIFICATION_URL", "http://localhost:8080/fittrme-api/verify-email", rawToken)),
+	})
+}
+
+// VerifyEmail marks the user's email as verified using the token from the verification link.
+func VerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing token"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Step 1: Find an unused, unexpired token with this hash
+	var tokenID, userID int
+	var email string
+	err = tx.QueryRow(`
+	SELECT id, user_id, email FROM email_verification_tokens
+	WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+	FOR UPDATE
+`, utils.HashToken(token)).Scan(&tokenID, &userID, &email)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Step 2: Consume the token and verify the address, as long as it is still the user's email
+	_, err = tx.Exec(`UPDATE email_verification_tokens SET used_at = NOW() WHERE id = $1`, tokenID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+	res, err := tx.Exec(`
+	UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW())
+	WHERE user_id = $1 AND email = $2
+`, userID, email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	recordSecurityEvent(c, userID, models.EventEmailVerified, "", gin.H{"email": email})
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
+}
+
+// ResendVerificationEmail sends a fresh verification link to an unverified account.
+// It is public, since under the block policy an unverified user cannot log in,
+// and rate limited per account to stop it from being used to flood an inbox.
+func ResendVerificationEmail(c *gin.Context) {
+	var input models.ResendVerificationInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	email := normalizeEmail(input.Email)
+	response := gin.H{"message": "If that email belongs to an unverified account, a new verification link has been sent"}
+
+	// Step 1: Only unverified accounts get a new link; everyone else gets the same response
+	var userID int
+	err := database.DB.QueryRow(`
+	SELECT user_id FROM users WHERE email = $1 AND email_verified_at IS NULL
+`, email).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusOK, response)
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Step 2: Rate limit on the tokens already issued: one per interval, a handful per day
+	wait, err := sendWait(verificationLimit, `
+	SELECT created_at FROM email_verification_tokens WHERE user_id = $1 ORDER BY created_at DESC
+`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		if wait > verificationLimit.interval {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many verification emails requested today"})
+		} else {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Please wait before requesting another verification email"})
+		}
+		return
+	}
+
+	// Step 3: Send the new link
+	if err := sendVerificationEmail(userID, email); err != nil {
+		log.Println("Failed to send verification email:", err)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
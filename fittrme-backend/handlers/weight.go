@@ -2,14 +2,29 @@ package handlers
 
 import (
 	"database/sql"
+	"encoding/base64"
+	"errors"
 	"fittrme-backend/database"
 	"fittrme-backend/models"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	weightsDefaultLimit = 50
+	weightsMaxLimit     = 500
+	// weightMaxClockSkew is how far in the future a client-supplied measured_at may be.
+	weightMaxClockSkew = time.Hour
+)
+
+// weightColumns is the column list scanWeight expects, in order.
+const weightColumns = `id, user_id, current_weight, target_weight, height, measured_at, version`
+
 func extractUserID(c *gin.Context) (int, bool) {
 	uidI, exists := c.Get("userId")
 	if !exists {
@@ -29,66 +44,249 @@ func extractUserID(c *gin.Context) (int, bool) {
 	}
 	return 0, false
 }
+
+// scanWeight scans a row selected with weightColumns into a models.Weight.
+func scanWeight(row interface{ Scan(...interface{}) error }) (models.Weight, error) {
+	var w models.Weight
+	var current, target, height sql.NullFloat64
+	var measuredAt time.Time
+	err := row.Scan(&w.ID, &w.UserID, &current, &target, &height, &measuredAt, &w.Version)
+	w.CurrentWeight = current.Float64
+	w.TargetWeight = target.Float64
+	w.Height = height.Float64
+	w.Unit = models.UnitKg
+	w.HeightUnit = models.UnitCm
+	w.MeasuredAt = &measuredAt
+	return w, err
+}
+
+// GetWeight returns the user's latest weigh-in.
 func GetWeight(c *gin.Context) {
 	userId, ok := extractUserID(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
 		return
 	}
+	system, ok := userUnitSystem(c, userId)
+	if !ok {
+		return
+	}
 
-	// Query using your REAL column: dm_lstupddt
-	row := database.DB.QueryRow(`
-        SELECT user_id, current_weight, target_weight, height,
-               COALESCE(dm_lstupddt, NOW())
+	weight, err := scanWeight(database.DB.QueryRow(`
+        SELECT `+weightColumns+`
         FROM weights
-        WHERE user_id = $1
-        ORDER BY dm_lstupddt DESC
+        WHERE user_id = $1 AND deleted_at IS NULL
+        ORDER BY measured_at DESC, id DESC
         LIMIT 1
-    `, userId)
-
-	var uid sql.NullInt64
-	var current sql.NullFloat64
-	var target sql.NullFloat64
-	var height sql.NullFloat64
-	var measuredAt sql.NullString
-
-	err := row.Scan(&uid, &current, &target, &height, &measuredAt)
-	if err == sql.ErrNoRows {
+    `, userId))
+	if errors.Is(err, sql.ErrNoRows) {
 		c.JSON(http.StatusNotFound, gin.H{"message": "No weight record found for this user"})
 		return
 	} else if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":  "Database error",
-			"detail": err.Error(),
-		})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
 
-	weight := models.Weight{
-		UserID:        int(uid.Int64),
-		CurrentWeight: current.Float64,
-		TargetWeight:  target.Float64,
-		Height:        height.Float64,
-	}
-
 	c.JSON(http.StatusOK, gin.H{
 		"userId": userId,
-		"weight": gin.H{
-			"userId":        weight.UserID,
-			"currentWeight": weight.CurrentWeight,
-			"targetWeight":  weight.TargetWeight,
-			"height":        weight.Height,
-			"measured_at":   measuredAt.String, // returning dm_lstupddt as measured_at
-		},
+		"weight": weightInUnits(weight, system),
 	})
 }
 
+// encodeHistoryCursor and decodeHistoryCursor turn the position after the last entry
+// of a page of weigh-ins or measurements into an opaque cursor. Pages are ordered by
+// (measured_at, id), newest first.
+func encodeHistoryCursor(measuredAt time.Time, id int64) string {
+	return base64.RawURLEncoding.EncodeToString(fmt.Appendf(nil, "%d:%d", measuredAt.UnixMicro(), id))
+}
+
+func decodeHistoryCursor(cursor string) (time.Time, int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	var micros, id int64
+	if _, err := fmt.Sscanf(string(raw), "%d:%d", &micros, &id); err != nil {
+		return time.Time{}, 0, err
+	}
+	return time.UnixMicro(micros), id, nil
+}
+
+// parseHistoryTime reads a from/to query parameter: either an RFC 3339 timestamp or a
+// YYYY-MM-DD date in the user's timezone. With endOfDay, a date means the end of that
+// day, so to=2025-01-31 includes weigh-ins on the 31st.
+func parseHistoryTime(value string, loc *time.Location, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	day, err := time.ParseInLocation(time.DateOnly, value, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day, nil
+}
+
+// historyQuery builds the query for one page of a history listing (weigh-ins,
+// measurements, security events). Conditions are ANDed; arguments are numbered in
+// the order they are added.
+type historyQuery struct {
+	where []string
+	args  []interface{}
+}
+
+// arg adds a query argument and returns its placeholder.
+func (q *historyQuery) arg(v interface{}) string {
+	q.args = append(q.args, v)
+	return "$" + strconv.Itoa(len(q.args))
+}
+
+// and adds a condition.
+func (q *historyQuery) and(condition string) {
+	q.where = append(q.where, condition)
+}
+
+// build returns selectFrom restricted by the conditions, ordered by orderBy and
+// limited to limit rows.
+func (q *historyQuery) build(selectFrom, orderBy string, limit int) string {
+	query := selectFrom
+	if len(q.where) > 0 {
+		query += " WHERE " + strings.Join(q.where, " AND ")
+	}
+	return query + " ORDER BY " + orderBy + " LIMIT " + q.arg(limit)
+}
+
+// pageLimit reads the limit query parameter, capped at maxLimit. It writes the error
+// response itself and returns false if the parameter is invalid.
+func pageLimit(c *gin.Context, defaultLimit, maxLimit int) (int, bool) {
+	s := c.Query("limit")
+	if s == "" {
+		return defaultLimit, true
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return 0, false
+	}
+	return min(n, maxLimit), true
+}
+
+// historyFilters adds the from, to and cursor query parameters of ListWeights to q, for
+// entries ordered by (timeColumn, idColumn) and dates in loc. It writes the error
+// response itself and returns false if a parameter is invalid.
+func historyFilters(c *gin.Context, q *historyQuery, loc *time.Location, timeColumn, idColumn string) bool {
+	if s := c.Query("from"); s != "" {
+		from, err := parseHistoryTime(s, loc, false)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected YYYY-MM-DD or RFC 3339"})
+			return false
+		}
+		q.and(timeColumn + " >= " + q.arg(from))
+	}
+	if s := c.Query("to"); s != "" {
+		to, err := parseHistoryTime(s, loc, true)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, expected YYYY-MM-DD or RFC 3339"})
+			return false
+		}
+		// An RFC 3339 bound is inclusive; a date was already moved to the start of the next day
+		if _, rfcErr := time.Parse(time.RFC3339, s); rfcErr == nil {
+			q.and(timeColumn + " <= " + q.arg(to))
+		} else {
+			q.and(timeColumn + " < " + q.arg(to))
+		}
+	}
+	if s := c.Query("cursor"); s != "" {
+		measuredAt, id, err := decodeHistoryCursor(s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return false
+		}
+		q.and("(" + timeColumn + ", " + idColumn + ") < (" + q.arg(measuredAt) + ", " + q.arg(id) + ")")
+	}
+	return true
+}
+
+// ListWeights returns the user's weigh-ins, newest first, one page at a time.
+// Query parameters: from and to (RFC 3339 or YYYY-MM-DD, both inclusive), limit, cursor
+// and units (see unitSystem).
+func ListWeights(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+
+	user, err := findUserByID(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	loc, err := time.LoadLocation(user.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	system, ok := unitSystem(c, user.Units)
+	if !ok {
+		return
+	}
+
+	// Step 1: Parse the filters
+	var q historyQuery
+	q.and("user_id = " + q.arg(userId))
+	q.and("deleted_at IS NULL")
+	limit, ok := pageLimit(c, weightsDefaultLimit, weightsMaxLimit)
+	if !ok || !historyFilters(c, &q, loc, "measured_at", "id") {
+		return
+	}
+	query := q.build(`SELECT `+weightColumns+` FROM weights`, "measured_at DESC, id DESC", limit+1)
+
+	// Step 2: Fetch one extra row to tell whether there is another page
+	rows, err := database.DB.Query(query, q.args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	weights := []models.Weight{}
+	for rows.Next() {
+		w, err := scanWeight(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		weights = append(weights, weightInUnits(w, system))
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	var nextCursor *string
+	if len(weights) > limit {
+		weights = weights[:limit]
+		cursor := encodeHistoryCursor(*weights[limit-1].MeasuredAt, weights[limit-1].ID)
+		nextCursor = &cursor
+	}
+	c.JSON(http.StatusOK, gin.H{"weights": weights, "nextCursor": nextCursor})
+}
+
+// SaveWeight records a weigh-in. Posting again with the same measured_at updates that
+// weigh-in instead of adding another, so a client can safely retry.
+// To fix a mistake in an earlier weigh-in, clients use UpdateWeight instead.
 func SaveWeight(c *gin.Context) {
 	userId, ok := extractUserID(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
 		return
 	}
+	system, ok := userUnitSystem(c, userId)
+	if !ok {
+		return
+	}
 
 	var payload models.Weight
 	if err := c.ShouldBindJSON(&payload); err != nil {
@@ -100,29 +298,37 @@ func SaveWeight(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input values"})
 		return
 	}
-	_, err := database.DB.Exec(`
-    INSERT INTO weights (user_id, current_weight, target_weight, height, dm_lstupddt)
-    VALUES ($1, $2, $3, $4, NOW())
-    ON CONFLICT (user_id)
-    DO UPDATE SET 
+	payload.CurrentWeight = storedWeight(payload.CurrentWeight, payload.Unit, models.UnitKg)
+	payload.TargetWeight = storedWeight(payload.TargetWeight, payload.Unit, models.UnitKg)
+	payload.Height = storedWeight(payload.Height, payload.HeightUnit, models.UnitCm)
+	measuredAt := time.Now()
+	if payload.MeasuredAt != nil {
+		measuredAt = *payload.MeasuredAt
+	}
+	if measuredAt.After(time.Now().Add(weightMaxClockSkew)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "measured_at cannot be in the future"})
+		return
+	}
+
+	weight, err := scanWeight(database.DB.QueryRow(`
+    INSERT INTO weights (user_id, current_weight, target_weight, height, measured_at, dm_lstupddt)
+    VALUES ($1, $2, $3, $4, $5, NOW())
+    ON CONFLICT (user_id, measured_at) WHERE deleted_at IS NULL
+    DO UPDATE SET
         current_weight = EXCLUDED.current_weight,
         target_weight = EXCLUDED.target_weight,
         height = EXCLUDED.height,
+        version = weights.version + 1,
         dm_lstupddt = NOW()
-`, userId, payload.CurrentWeight, payload.TargetWeight, payload.Height)
-
+    RETURNING `+weightColumns+`
+`, userId, payload.CurrentWeight, payload.TargetWeight, payload.Height, measuredAt))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save weight data", "detail": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save weight data"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Weight data saved successfully",
-		"weight": gin.H{
-			"userId":        userId,
-			"currentWeight": payload.CurrentWeight,
-			"targetWeight":  payload.TargetWeight,
-			"height":        payload.Height,
-		},
+		"weight":  weightInUnits(weight, system),
 	})
 }
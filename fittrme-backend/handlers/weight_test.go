@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func historyContext(query string) (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+	return c, w
+}
+
+func TestHistoryQuery(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	cursorAt := time.UnixMicro(1700000000000000)
+	cursor := encodeHistoryCursor(cursorAt, 42)
+
+	c, _ := historyContext("limit=1000&from=2025-01-01&to=2025-01-31&cursor=" + cursor)
+	var q historyQuery
+	q.and("m.user_id = " + q.arg(7))
+	limit, ok := pageLimit(c, weightsDefaultLimit, weightsMaxLimit)
+	if !ok || limit != weightsMaxLimit {
+		t.Fatalf("limit = %d, %v; want %d", limit, ok, weightsMaxLimit)
+	}
+	if !historyFilters(c, &q, loc, "m.measured_at", "m.id") {
+		t.Fatal("filters rejected")
+	}
+
+	got := q.build("SELECT * FROM body_measurements m", "m.measured_at DESC, m.id DESC", limit+1)
+	want := "SELECT * FROM body_measurements m WHERE m.user_id = $1 AND m.measured_at >= $2" +
+		" AND m.measured_at < $3 AND (m.measured_at, m.id) < ($4, $5)" +
+		" ORDER BY m.measured_at DESC, m.id DESC LIMIT $6"
+	if got != want {
+		t.Fatalf("query =\n%s\nwant\n%s", got, want)
+	}
+	wantArgs := []interface{}{
+		7,
+		time.Date(2025, 1, 1, 0, 0, 0, 0, loc),
+		time.Date(2025, 2, 1, 0, 0, 0, 0, loc), // a to date includes the whole day
+		cursorAt, int64(42),
+		weightsMaxLimit + 1,
+	}
+	if !reflect.DeepEqual(q.args, wantArgs) {
+		t.Fatalf("args = %v, want %v", q.args, wantArgs)
+	}
+}
+
+func TestHistoryQueryInclusiveTo(t *testing.T) {
+	c, _ := historyContext("to=2025-01-31T12:00:00Z")
+	var q historyQuery
+	if !historyFilters(c, &q, time.UTC, "measured_at", "id") {
+		t.Fatal("filters rejected")
+	}
+	if got := q.build("SELECT * FROM weights", "id", 1); got != "SELECT * FROM weights WHERE measured_at <= $1 ORDER BY id LIMIT $2" {
+		t.Fatalf("query = %s", got)
+	}
+}
+
+func TestHistoryQueryInvalid(t *testing.T) {
+	for _, query := range []string{"limit=0", "limit=ten", "from=31.01.2025", "to=yesterday", "cursor=!!"} {
+		c, w := historyContext(query)
+		var q historyQuery
+		_, ok := pageLimit(c, weightsDefaultLimit, weightsMaxLimit)
+		if ok {
+			ok = historyFilters(c, &q, time.UTC, "measured_at", "id")
+		}
+		if ok || w.Code != http.StatusBadRequest {
+			t.Errorf("%s: ok = %v, status %d; want 400", query, ok, w.Code)
+		}
+	}
+}
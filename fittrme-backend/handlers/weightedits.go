@@ -0,0 +1,382 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fittrme-backend/database"
+	"fittrme-backend/jobs"
+	"fittrme-backend/models"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Editing and deleting single weigh-ins. Edits use optimistic concurrency: the client
+// sends the version it last saw, either as "version" in the body or as the ETag in an
+// If-Match header, and the edit is refused with 412 if another device changed the
+// weigh-in since. Deletes are soft; RestoreWeight undoes them within jobs.EntryUndoWindow.
+// Body measurements are edited the same way; the shared parts take an entryKind.
+
+// entryETag is the ETag of one version of a weigh-in or body measurement.
+func entryETag(id int64, version int) string {
+	return fmt.Sprintf(`"%d-%d"`, id, version)
+}
+
+// weightIDParam reads the :id path parameter of the /weights/:id routes.
+func weightIDParam(c *gin.Context) (int64, bool) {
+	weightID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid weight id"})
+		return 0, false
+	}
+	return weightID, true
+}
+
+// expectedVersion returns the version of entry id the client based its edit on, from
+// the If-Match header or else from the body. Without either it responds 428, since an
+// unconditional edit could silently overwrite another device's change.
+func expectedVersion(c *gin.Context, id int64, bodyVersion *int) (int, bool) {
+	if ifMatch := c.GetHeader("If-Match"); ifMatch != "" {
+		var tagID int64
+		var version int
+		tag := strings.TrimPrefix(strings.TrimSpace(ifMatch), "W/")
+		if _, err := fmt.Sscanf(tag, `"%d-%d"`, &tagID, &version); err != nil || tagID != id {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": "If-Match does not match this entry"})
+			return 0, false
+		}
+		return version, true
+	}
+	if bodyVersion != nil {
+		return *bodyVersion, true
+	}
+	c.JSON(http.StatusPreconditionRequired, gin.H{"error": "Send the version you are editing, as \"version\" or an If-Match header"})
+	return 0, false
+}
+
+// entryKind describes a kind of versioned, soft-deletable entry (weigh-ins, body
+// measurements) to the edit, delete and restore code they share.
+type entryKind[T any] struct {
+	table     string // table the entries are stored in
+	updatedAt string // its last-modified column
+	// selectFrom selects the columns scan expects from e, the row being read or changed.
+	selectFrom string
+	scan       func(row interface{ Scan(...interface{}) error }) (T, error)
+	idVersion  func(T) (int64, int)
+	inUnits    func(T, string) T
+	idParam    func(*gin.Context) (int64, bool)
+
+	key      string // response field an entry is returned in
+	name     string // capitalized name in messages, e.g. "Weight entry"
+	noun     string // name in sentences, e.g. "weigh-in"
+	conflict string // error when another entry already has the same measured_at
+}
+
+var weightEntries = entryKind[models.Weight]{
+	table:      "weights",
+	updatedAt:  "dm_lstupddt",
+	selectFrom: `SELECT ` + weightColumns + ` FROM e`,
+	scan:       scanWeight,
+	idVersion:  func(w models.Weight) (int64, int) { return w.ID, w.Version },
+	inUnits:    weightInUnits,
+	idParam:    weightIDParam,
+	key:        "weight",
+	name:       "Weight entry",
+	noun:       "weigh-in",
+	conflict:   "Another weigh-in already has this measured_at",
+}
+
+// lockEntry loads one of the user's entries for an edit, locking the row.
+// Entries of other users are reported as not found. It writes the error response
+// itself and returns false if the request should stop.
+func lockEntry[T any](c *gin.Context, tx *sql.Tx, kind entryKind[T], id int64, userID int, deleted bool) (T, bool) {
+	condition := "deleted_at IS NULL"
+	if deleted {
+		condition = "deleted_at IS NOT NULL"
+	}
+	entry, err := kind.scan(tx.QueryRow(`
+	WITH e AS (
+		SELECT * FROM `+kind.table+` WHERE id = $1 AND user_id = $2 AND `+condition+` FOR UPDATE
+	)
+	`+kind.selectFrom, id, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": kind.name + " not found"})
+		return entry, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return entry, false
+	}
+	return entry, true
+}
+
+// checkEntryVersion responds 412 with the current entry and its ETag unless the entry
+// is still at the version the client wants to change.
+func checkEntryVersion[T any](c *gin.Context, kind entryKind[T], entry T, wantVersion int, system string) bool {
+	id, version := kind.idVersion(entry)
+	if version == wantVersion {
+		return true
+	}
+	c.Header("ETag", entryETag(id, version))
+	c.JSON(http.StatusPreconditionFailed, gin.H{
+		"error":  "This " + kind.noun + " was changed on another device, please review and try again",
+		kind.key: kind.inUnits(entry, system),
+	})
+	return false
+}
+
+// softDeleteEntry soft-deletes the entry in the path. It disappears from every read at
+// once and can be restored with restoreEntry until the undo window ends; the cleanup
+// job removes it for good afterwards. An If-Match header is honoured but not required.
+func softDeleteEntry[T any](c *gin.Context, kind entryKind[T]) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+	id, ok := kind.idParam(c)
+	if !ok {
+		return
+	}
+	system, ok := userUnitSystem(c, userId)
+	if !ok {
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	entry, ok := lockEntry(c, tx, kind, id, userId, false)
+	if !ok {
+		return
+	}
+	if c.GetHeader("If-Match") != "" {
+		wantVersion, ok := expectedVersion(c, id, nil)
+		if !ok || !checkEntryVersion(c, kind, entry, wantVersion, system) {
+			return
+		}
+	}
+
+	var deletedAt time.Time
+	err = tx.QueryRow(`
+	UPDATE `+kind.table+` SET deleted_at = NOW(), version = version + 1, `+kind.updatedAt+` = NOW()
+	WHERE id = $1
+	RETURNING deleted_at
+`, id).Scan(&deletedAt)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete " + strings.ToLower(kind.name)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   kind.name + " deleted",
+		"undoUntil": deletedAt.Add(jobs.EntryUndoWindow()),
+	})
+}
+
+// restoreEntry undoes softDeleteEntry within the undo window.
+func restoreEntry[T any](c *gin.Context, kind entryKind[T]) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+	id, ok := kind.idParam(c)
+	if !ok {
+		return
+	}
+	system, ok := userUnitSystem(c, userId)
+	if !ok {
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, ok := lockEntry(c, tx, kind, id, userId, true); !ok {
+		return
+	}
+
+	entry, err := kind.scan(tx.QueryRow(`
+	WITH e AS (
+		UPDATE `+kind.table+` SET deleted_at = NULL, version = version + 1, `+kind.updatedAt+` = NOW()
+		WHERE id = $1 AND deleted_at > NOW() - $2::INTERVAL
+		RETURNING *
+	)
+	`+kind.selectFrom, id, fmt.Sprintf("%d seconds", int(jobs.EntryUndoWindow().Seconds()))))
+	if err == nil {
+		err = tx.Commit()
+	}
+	var pqErr *pq.Error
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusGone, gin.H{"error": "This " + kind.noun + " can no longer be restored"})
+		return
+	} else if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		c.JSON(http.StatusConflict, gin.H{"error": kind.conflict})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore " + strings.ToLower(kind.name)})
+		return
+	}
+
+	id, version := kind.idVersion(entry)
+	c.Header("ETag", entryETag(id, version))
+	c.JSON(http.StatusOK, gin.H{"message": kind.name + " restored", kind.key: kind.inUnits(entry, system)})
+}
+
+// GetWeightEntry returns one weigh-in with its ETag.
+func GetWeightEntry(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+	weightID, ok := weightIDParam(c)
+	if !ok {
+		return
+	}
+	system, ok := userUnitSystem(c, userId)
+	if !ok {
+		return
+	}
+
+	weight, err := scanWeight(database.DB.QueryRow(`
+	SELECT `+weightColumns+` FROM weights WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+`, weightID, userId))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Weight entry not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.Header("ETag", entryETag(weight.ID, weight.Version))
+	c.JSON(http.StatusOK, gin.H{"weight": weightInUnits(weight, system)})
+}
+
+// UpdateWeight replaces all fields of a weigh-in (PUT /weights/:id).
+func UpdateWeight(c *gin.Context) {
+	var input models.UpdateWeightInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	editWeight(c, input.Version, func(w *models.Weight) {
+		w.CurrentWeight = storedWeight(input.CurrentWeight, input.Unit, models.UnitKg)
+		w.TargetWeight = storedWeight(input.TargetWeight, input.Unit, models.UnitKg)
+		w.Height = storedWeight(input.Height, input.HeightUnit, models.UnitCm)
+		w.MeasuredAt = input.MeasuredAt
+	})
+}
+
+// PatchWeight changes only the fields present in the request (PATCH /weights/:id).
+func PatchWeight(c *gin.Context) {
+	var input models.PatchWeightInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	editWeight(c, input.Version, func(w *models.Weight) {
+		if input.CurrentWeight != nil {
+			w.CurrentWeight = storedWeight(*input.CurrentWeight, input.Unit, models.UnitKg)
+		}
+		if input.TargetWeight != nil {
+			w.TargetWeight = storedWeight(*input.TargetWeight, input.Unit, models.UnitKg)
+		}
+		if input.Height != nil {
+			w.Height = storedWeight(*input.Height, input.HeightUnit, models.UnitCm)
+		}
+		if input.MeasuredAt != nil {
+			w.MeasuredAt = input.MeasuredAt
+		}
+	})
+}
+
+// editWeight applies an edit to the weigh-in in the path if the client's version is current.
+func editWeight(c *gin.Context, bodyVersion *int, apply func(*models.Weight)) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+	weightID, ok := weightIDParam(c)
+	if !ok {
+		return
+	}
+	wantVersion, ok := expectedVersion(c, weightID, bodyVersion)
+	if !ok {
+		return
+	}
+	system, ok := userUnitSystem(c, userId)
+	if !ok {
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Step 1: Load the weigh-in and check nobody changed it in the meantime
+	weight, ok := lockEntry(c, tx, weightEntries, weightID, userId, false)
+	if !ok || !checkEntryVersion(c, weightEntries, weight, wantVersion, system) {
+		return
+	}
+
+	// Step 2: Apply and validate the edit
+	apply(&weight)
+	if weight.MeasuredAt.After(time.Now().Add(weightMaxClockSkew)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "measured_at cannot be in the future"})
+		return
+	}
+
+	// Step 3: Save it as the next version
+	weight, err = scanWeight(tx.QueryRow(`
+	UPDATE weights
+	SET current_weight = $1, target_weight = $2, height = $3, measured_at = $4,
+		version = version + 1, dm_lstupddt = NOW()
+	WHERE id = $5
+	RETURNING `+weightColumns+`
+`, weight.CurrentWeight, weight.TargetWeight, weight.Height, *weight.MeasuredAt, weightID))
+	if err == nil {
+		err = tx.Commit()
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		c.JSON(http.StatusConflict, gin.H{"error": weightEntries.conflict})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update weight entry"})
+		return
+	}
+
+	c.Header("ETag", entryETag(weight.ID, weight.Version))
+	c.JSON(http.StatusOK, gin.H{"message": "Weight entry updated", "weight": weightInUnits(weight, system)})
+}
+
+// DeleteWeight soft-deletes a weigh-in; see softDeleteEntry.
+func DeleteWeight(c *gin.Context) {
+	softDeleteEntry(c, weightEntries)
+}
+
+// RestoreWeight undoes DeleteWeight within the undo window.
+func RestoreWeight(c *gin.Context) {
+	restoreEntry(c, weightEntries)
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fittrme-backend/database"
+	"fittrme-backend/models"
+	"fittrme-backend/utils"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	trendDefaultWindow = 10 // days; alpha = 2 / (window + 1)
+	trendMinWindow     = 2
+	trendMaxWindow     = 60
+	trendDefaultDays   = 90 // days of trend line returned
+	trendMaxDays       = 730
+	// trendMinRateDays is the shortest period the weekly rate is fitted over, so a few
+	// days of water weight do not dominate it.
+	trendMinRateDays = 14
+	// trendMaxProjectionDays caps goal projections; further out they are not meaningful.
+	trendMaxProjectionDays = 5 * 365
+	// trendGoalTolerance is how close to the target (in kg) counts as reached.
+	trendGoalTolerance = 0.1
+	// trendConfidenceZ gives a 95% confidence band on the rate.
+	trendConfidenceZ = 1.96
+)
+
+// queryIntParam reads an integer query parameter within [minValue, maxValue], or def if absent.
+func queryIntParam(c *gin.Context, name string, def, minValue, maxValue int) (int, bool) {
+	s := c.Query(name)
+	if s == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < minValue || n > maxValue {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name + ", expected a number from " +
+			strconv.Itoa(minValue) + " to " + strconv.Itoa(maxValue)})
+		return 0, false
+	}
+	return n, true
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// GetWeightTrend returns the smoothed trend of the user's weigh-ins, the weekly rate of
+// change and when the target weight will be reached at that rate.
+//
+// Query parameters: window (smoothing window in days, default 10), days (how many
+// days of trend line to return, default 90) and units (see unitSystem). Weigh-ins are
+// grouped into calendar days in the user's timezone; see utils.EWMATrend for how days
+// without one are handled. Everything is computed in kg and converted at the end.
+func GetWeightTrend(c *gin.Context) {
+	userId, ok := extractUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user id missing"})
+		return
+	}
+	window, ok := queryIntParam(c, "window", trendDefaultWindow, trendMinWindow, trendMaxWindow)
+	if !ok {
+		return
+	}
+	days, ok := queryIntParam(c, "days", trendDefaultDays, 1, trendMaxDays)
+	if !ok {
+		return
+	}
+
+	user, err := findUserByID(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	loc, err := time.LoadLocation(user.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	system, ok := unitSystem(c, user.Units)
+	if !ok {
+		return
+	}
+	inUnits := func(kg float64) float64 {
+		v, _ := fromStoredUnit(kg, models.UnitKg, system)
+		return v
+	}
+
+	// Step 1: Daily means in the user's timezone. The EWMA starts a few windows before
+	// the returned period, so the first returned days are already smoothed.
+	warmUp := 3 * window
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	firstDay := today.AddDate(0, 0, -(days - 1))
+	rows, err := database.DB.Query(`
+	SELECT (measured_at AT TIME ZONE $2)::DATE AS day, AVG(current_weight)::FLOAT8
+	FROM weights
+	WHERE user_id = $1 AND deleted_at IS NULL AND current_weight IS NOT NULL
+		AND (measured_at AT TIME ZONE $2)::DATE >= $3::DATE
+	GROUP BY day
+	ORDER BY day
+`, userId, loc.String(), firstDay.AddDate(0, 0, -warmUp).Format(time.DateOnly))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+	var daily []utils.DailyWeight
+	for rows.Next() {
+		var d utils.DailyWeight
+		if err := rows.Scan(&d.Day, &d.Weight); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		d.Day = time.Date(d.Day.Year(), d.Day.Month(), d.Day.Day(), 0, 0, 0, 0, time.UTC)
+		daily = append(daily, d)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if len(daily) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": "No weight records in this period"})
+		return
+	}
+
+	// Step 2: The trend line, trimmed to the requested period
+	points := utils.EWMATrend(daily, window)
+	for len(points) > 0 && points[0].Date < firstDay.Format(time.DateOnly) {
+		points = points[1:]
+	}
+	if len(points) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": "No weight records in this period"})
+		return
+	}
+	lastDay := daily[len(daily)-1].Day
+	currentTrend := points[len(points)-1].Trend
+	for i := range points {
+		points[i].Trend = inUnits(points[i].Trend)
+		if points[i].Weight != nil {
+			weight := inUnits(*points[i].Weight)
+			points[i].Weight = &weight
+		}
+	}
+
+	// Step 3: Weekly rate, fitted over the last max(window, 14) days up to the latest weigh-in
+	rateDays := max(window, trendMinRateDays)
+	var recent []utils.DailyWeight
+	for _, d := range daily {
+		if lastDay.Sub(d.Day) < time.Duration(rateDays)*24*time.Hour {
+			recent = append(recent, d)
+		}
+	}
+	slope, stdErr, haveRate := utils.LinearRate(recent)
+	var weeklyRate gin.H
+	if haveRate {
+		weeklyRate = gin.H{
+			"value": inUnits(slope * 7),
+			"low":   inUnits((slope - trendConfidenceZ*stdErr) * 7),
+			"high":  inUnits((slope + trendConfidenceZ*stdErr) * 7),
+			"days":  rateDays,
+		}
+	}
+
+	// Step 4: Project the goal date from the latest target weight
+	var target sql.NullFloat64
+	err = database.DB.QueryRow(`
+	SELECT target_weight::FLOAT8 FROM weights
+	WHERE user_id = $1 AND deleted_at IS NULL
+	ORDER BY measured_at DESC, id DESC
+	LIMIT 1
+`, userId).Scan(&target)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	var goal gin.H
+	if target.Valid && target.Float64 > 0 {
+		goal = projectGoal(currentTrend, target.Float64, lastDay, slope, stdErr, haveRate, inUnits)
+	}
+
+	_, unit := fromStoredUnit(0, models.UnitKg, system)
+	c.JSON(http.StatusOK, gin.H{
+		"unit":       unit,
+		"window":     window,
+		"days":       days,
+		"points":     points,
+		"trend":      inUnits(currentTrend),
+		"weeklyRate": weeklyRate,
+		"goal":       goal,
+	})
+}
+
+// projectGoal estimates when the trend reaches target at the fitted rate. Weights and
+// rates are in kg; inUnits converts the weights it returns. The confidence band comes
+// from the rate's 95% interval: the faster end gives the earliest date, the slower end
+// the latest. A date is null when that rate does not reach the target within
+// trendMaxProjectionDays (e.g. it is flat or going the wrong way).
+func projectGoal(current, target float64, from time.Time, slope, stdErr float64, haveRate bool, inUnits func(kg float64) float64) gin.H {
+	reached := math.Abs(target-current) <= trendGoalTolerance
+	goal := gin.H{
+		"targetWeight": inUnits(target),
+		"remaining":    inUnits(target - current),
+		"reached":      reached,
+		"onTrack":      false,
+		"projectedAt":  nil,
+		"earliestAt":   nil,
+		"latestAt":     nil,
+	}
+	if reached || !haveRate {
+		return goal
+	}
+
+	dateAt := func(rate float64) *string {
+		days, ok := utils.DaysToTarget(current, target, rate)
+		if !ok || days > trendMaxProjectionDays {
+			return nil
+		}
+		date := from.AddDate(0, 0, int(math.Ceil(days))).Format(time.DateOnly)
+		return &date
+	}
+	projected := dateAt(slope)
+	goal["onTrack"] = projected != nil
+	goal["projectedAt"] = projected
+
+	// Whichever end of the interval moves toward the target faster gives the earliest date
+	fast, slow := slope+trendConfidenceZ*stdErr, slope-trendConfidenceZ*stdErr
+	if target < current {
+		fast, slow = slow, fast
+	}
+	goal["earliestAt"] = dateAt(fast)
+	goal["latestAt"] = dateAt(slow)
+	return goal
+}
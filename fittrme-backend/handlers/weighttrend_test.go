@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProjectGoal(t *testing.T) {
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	date := func(days int) string { return from.AddDate(0, 0, days).Format(time.DateOnly) }
+	tests := []struct {
+		name                   string
+		current, target        float64
+		slope, stdErr          float64
+		haveRate               bool
+		wantOnTrack            bool
+		projected, early, late string // "" for null
+	}{
+		{
+			// Rate -0.5 ± 1.96*0.1 kg/day: 5 kg take 10 days, 7.2 at the fast end and 16.4 at the slow end
+			name: "losing", current: 80, target: 75, slope: -0.5, stdErr: 0.1, haveRate: true,
+			wantOnTrack: true, projected: date(10), early: date(8), late: date(17),
+		},
+		{
+			name: "gaining", current: 60, target: 65, slope: 0.5, stdErr: 0.1, haveRate: true,
+			wantOnTrack: true, projected: date(10), early: date(8), late: date(17),
+		},
+		{
+			// The slow end of the band is gaining, so there is no latest date
+			name: "band crosses zero", current: 80, target: 75, slope: -0.1, stdErr: 0.1, haveRate: true,
+			wantOnTrack: true, projected: date(50), early: date(17),
+		},
+		{
+			name: "exact rate", current: 80, target: 75, slope: -0.5, haveRate: true,
+			wantOnTrack: true, projected: date(10), early: date(10), late: date(10),
+		},
+		{name: "flat", current: 80, target: 75, haveRate: true},
+		{
+			// Even the fast end of the band is gaining
+			name: "wrong direction", current: 80, target: 75, slope: 0.2, stdErr: 0.1, haveRate: true,
+		},
+		{
+			name: "wrong direction within the band", current: 80, target: 75, slope: 0.1, stdErr: 0.1, haveRate: true,
+			early: date(53),
+		},
+		{name: "beyond the projection limit", current: 80, target: 75, slope: -0.001, haveRate: true},
+		{name: "no rate", current: 80, target: 75},
+		{name: "reached", current: 75.05, target: 75, slope: -0.5, stdErr: 0.1, haveRate: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			goal := projectGoal(tt.current, tt.target, from, tt.slope, tt.stdErr, tt.haveRate, func(kg float64) float64 { return kg })
+			if goal["onTrack"] != tt.wantOnTrack {
+				t.Errorf("onTrack = %v, want %v", goal["onTrack"], tt.wantOnTrack)
+			}
+			for key, want := range map[string]string{"projectedAt": tt.projected, "earliestAt": tt.early, "latestAt": tt.late} {
+				got := ""
+				if d, ok := goal[key].(*string); ok && d != nil {
+					got = *d
+				}
+				if got != want {
+					t.Errorf("%s = %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
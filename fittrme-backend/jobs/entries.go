@@ -0,0 +1,57 @@
+package jobs
+
+import (
+	"fittrme-backend/database"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// entryCleanupInterval is how often soft-deleted weigh-ins and body measurements past
+// their undo window are removed.
+const entryCleanupInterval = 10 * time.Minute
+
+// EntryUndoWindow reads how long a deleted weigh-in or body measurement can be restored
+// from ENTRY_UNDO_WINDOW_MIN (default 10 minutes). The older WEIGHT_UNDO_WINDOW_MIN is
+// still read when ENTRY_UNDO_WINDOW_MIN is not set.
+func EntryUndoWindow() time.Duration {
+	value := os.Getenv("ENTRY_UNDO_WINDOW_MIN")
+	if value == "" {
+		value = os.Getenv("WEIGHT_UNDO_WINDOW_MIN")
+	}
+	minutes, _ := strconv.Atoi(value)
+	if minutes == 0 {
+		minutes = 10
+	}
+	return time.Minute * time.Duration(minutes)
+}
+
+// StartEntryCleanup permanently deletes weigh-ins and body measurements that were
+// deleted longer than EntryUndoWindow ago, now and then every entryCleanupInterval.
+// It must be called after database.ConnectDB.
+func StartEntryCleanup() {
+	go func() {
+		for {
+			window := fmt.Sprintf("%d seconds", int(EntryUndoWindow().Seconds()))
+			res, err := database.DB.Exec(`
+	DELETE FROM weights WHERE deleted_at < NOW() - $1::INTERVAL
+`, window)
+			if err != nil {
+				log.Println("Weight cleanup failed:", err)
+			} else if n, _ := res.RowsAffected(); n > 0 {
+				log.Printf("Removed %d deleted weigh-ins", n)
+			}
+			res, err = database.DB.Exec(`
+	DELETE FROM body_measurements WHERE deleted_at < NOW() - $1::INTERVAL
+`, window)
+			if err != nil {
+				log.Println("Measurement cleanup failed:", err)
+			} else if n, _ := res.RowsAffected(); n > 0 {
+				log.Printf("Removed %d deleted body measurements", n)
+			}
+			time.Sleep(entryCleanupInterval)
+		}
+	}()
+}
@@ -0,0 +1,330 @@
+package jobs
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fittrme-backend/database"
+	"fittrme-backend/models"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Personal data exports are built by a worker, so a large history never blocks a request.
+// Each instance polls data_exports for pending requests; WakeExportWorker skips the wait
+// on the instance that took the request.
+
+const (
+	exportPollInterval = 5 * time.Second
+	// exportStaleAfter is when a "running" export is assumed lost with its instance and retried.
+	exportStaleAfter = 15 * time.Minute
+	// exportFormatVersion is bumped whenever the archive layout changes.
+	exportFormatVersion = 1
+)
+
+// Tables that are never exported: the exports themselves (they contain earlier archives).
+var exportSkipTables = map[string]bool{"data_exports": true}
+
+// exportHiddenColumn reports whether a column holds a credential or key material, which
+// is left out of exports: possession of an export must not let anyone sign in.
+func exportHiddenColumn(column string) bool {
+	return column == "password_hash" || strings.HasSuffix(column, "_hash") ||
+		strings.Contains(column, "secret") || column == "public_key" ||
+		column == "challenge" || column == "nonce" || column == "code_verifier"
+}
+
+var exportWake = make(chan struct{}, 1)
+
+// WakeExportWorker makes this instance's worker look for new exports right away.
+func WakeExportWorker() {
+	select {
+	case exportWake <- struct{}{}:
+	default:
+	}
+}
+
+// DataExportTTL reads how long a finished export can be downloaded from DATA_EXPORT_TTL_HOURS (default 48 hours).
+func DataExportTTL() time.Duration {
+	hours, _ := strconv.Atoi(os.Getenv("DATA_EXPORT_TTL_HOURS"))
+	if hours == 0 {
+		hours = 48
+	}
+	return time.Hour * time.Duration(hours)
+}
+
+// StartExportWorker builds requested data exports in the background and discards expired
+// archives. It must be called after database.ConnectDB.
+func StartExportWorker() {
+	go func() {
+		for {
+			for {
+				ok, err := runNextExport()
+				if err != nil {
+					log.Println("Data export worker:", err)
+				}
+				if !ok || err != nil {
+					break
+				}
+			}
+			if _, err := database.DB.Exec(`
+	UPDATE data_exports SET status = $1, archive = NULL
+	WHERE status = $2 AND expires_at <= NOW()
+`, models.ExportExpired, models.ExportReady); err != nil {
+				log.Println("Data export cleanup:", err)
+			}
+
+			select {
+			case <-exportWake:
+			case <-time.After(exportPollInterval):
+			}
+		}
+	}()
+}
+
+// runNextExport claims one pending export and builds it. It returns false if there was none.
+func runNextExport() (bool, error) {
+	var exportID, userID int
+	err := database.DB.QueryRow(`
+	UPDATE data_exports SET status = $1, started_at = NOW()
+	WHERE id = (
+		SELECT id FROM data_exports
+		WHERE status = $2 OR (status = $1 AND started_at < NOW() - $3::INTERVAL)
+		ORDER BY requested_at
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING id, user_id
+`, models.ExportRunning, models.ExportPending, fmt.Sprintf("%d seconds", int(exportStaleAfter.Seconds()))).Scan(&exportID, &userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	archive, err := buildExportArchive(userID)
+	if err != nil {
+		log.Printf("Data export %d failed: %v", exportID, err)
+		_, dbErr := database.DB.Exec(`
+	UPDATE data_exports SET status = $1, error = 'Export failed, please request a new one', completed_at = NOW()
+	WHERE id = $2
+`, models.ExportFailed, exportID)
+		return true, dbErr
+	}
+
+	_, err = database.DB.Exec(`
+	UPDATE data_exports SET status = $1, archive = $2, size_bytes = $3, completed_at = NOW(), expires_at = $4
+	WHERE id = $5
+`, models.ExportReady, archive, len(archive), time.Now().Add(DataExportTTL()), exportID)
+	return true, err
+}
+
+// exportFile describes one file of the archive in manifest.json.
+type exportFile struct {
+	Path   string `json:"path"`
+	Table  string `json:"table"`
+	Format string `json:"format"`
+	Rows   int    `json:"rows"`
+}
+
+// buildExportArchive writes every row that belongs to the user, one JSON and one CSV
+// file per table, plus a manifest. Tables are discovered from their foreign keys to
+// users, so a new table with user data is exported without changes here.
+func buildExportArchive(userID int) ([]byte, error) {
+	tables, err := userTables()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	var files []exportFile
+
+	for _, t := range tables {
+		columns, err := exportColumns(t.name)
+		if err != nil {
+			return nil, err
+		}
+		if len(columns) == 0 {
+			continue
+		}
+		name := t.name
+		if name == "users" {
+			name = "profile"
+		}
+
+		jsonRows, csvRows, err := exportRows(t.name, t.userColumn, columns, userID)
+		if err != nil {
+			return nil, fmt.Errorf("exporting %s: %w", t.name, err)
+		}
+
+		w, err := zw.Create("json/" + name + ".json")
+		if err == nil {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(jsonRows)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		w, err = zw.Create("csv/" + name + ".csv")
+		if err != nil {
+			return nil, err
+		}
+		cw := csv.NewWriter(w)
+		if err := cw.Write(columns); err != nil {
+			return nil, err
+		}
+		if err := cw.WriteAll(csvRows); err != nil {
+			return nil, err
+		}
+
+		files = append(files,
+			exportFile{Path: "json/" + name + ".json", Table: t.name, Format: "json", Rows: len(jsonRows)},
+			exportFile{Path: "csv/" + name + ".csv", Table: t.name, Format: "csv", Rows: len(csvRows)},
+		)
+	}
+
+	w, err := zw.Create("manifest.json")
+	if err != nil {
+		return nil, err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(map[string]interface{}{
+		"formatVersion": exportFormatVersion,
+		"userId":        userID,
+		"generatedAt":   time.Now().UTC(),
+		"note":          "Password hashes, token hashes and other secrets are not included.",
+		"files":         files,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type userTable struct {
+	name       string
+	userColumn string
+}
+
+// userTables returns users itself and every table whose user_id references it.
+func userTables() ([]userTable, error) {
+	rows, err := database.DB.Query(`
+	SELECT DISTINCT con.conrelid::regclass::text
+	FROM pg_constraint con
+	JOIN pg_attribute att ON att.attrelid = con.conrelid AND att.attnum = ANY(con.conkey)
+	WHERE con.contype = 'f' AND con.confrelid = 'users'::regclass AND att.attname = 'user_id'
+	ORDER BY 1
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tables := []userTable{{name: "users", userColumn: "user_id"}}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if !exportSkipTables[name] {
+			tables = append(tables, userTable{name: name, userColumn: "user_id"})
+		}
+	}
+	return tables, rows.Err()
+}
+
+// exportColumns returns the exportable columns of a table in their defined order.
+func exportColumns(table string) ([]string, error) {
+	rows, err := database.DB.Query(`
+	SELECT column_name FROM information_schema.columns
+	WHERE table_schema = current_schema() AND table_name = $1
+	ORDER BY ordinal_position
+`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		if !exportHiddenColumn(column) {
+			columns = append(columns, column)
+		}
+	}
+	return columns, rows.Err()
+}
+
+// exportRows reads the user's rows of a table, once as typed JSON objects and once as text for CSV.
+func exportRows(table, userColumn string, columns []string, userID int) ([]json.RawMessage, [][]string, error) {
+	quoted := make([]string, len(columns))
+	asText := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = pq.QuoteIdentifier(c)
+		asText[i] = quoted[i] + "::TEXT"
+	}
+	from := ` FROM ` + pq.QuoteIdentifier(table) + ` WHERE ` + pq.QuoteIdentifier(userColumn) + ` = $1 ORDER BY 1`
+
+	jsonRows := []json.RawMessage{}
+	rows, err := database.DB.Query(`SELECT row_to_json(t) FROM (SELECT `+strings.Join(quoted, ", ")+from+`) t`, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var row []byte
+		if err := rows.Scan(&row); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		jsonRows = append(jsonRows, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var csvRows [][]string
+	rows, err = database.DB.Query(`SELECT `+strings.Join(asText, ", ")+from, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		values := make([]*string, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, nil, err
+		}
+		record := make([]string, len(columns))
+		for i, v := range values {
+			if v != nil {
+				record[i] = *v
+			}
+		}
+		csvRows = append(csvRows, record)
+	}
+	return jsonRows, csvRows, rows.Err()
+}
@@ -0,0 +1,128 @@
+// Package jobs runs background work that is not tied to a request.
+package jobs
+
+import (
+	"database/sql"
+	"fittrme-backend/database"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// purgeInterval is how often accounts past their deletion grace period are purged.
+const purgeInterval = time.Hour
+
+// purgeBatchSize caps how many accounts one run deletes, to keep transactions short.
+const purgeBatchSize = 100
+
+// StartAccountPurge deletes accounts whose deletion grace period has ended, now and
+// then every purgeInterval. It must be called after database.ConnectDB.
+//
+// Deleting the users row removes everything else through ON DELETE CASCADE, so every
+// table holding user data must reference users(user_id) that way (see fittrme_db.sql).
+// A deleted_accounts tombstone with no personal data is kept for audit.
+func StartAccountPurge() {
+	warnUnpurgedTables()
+	go func() {
+		for {
+			if n, err := purgeDeletedAccounts(); err != nil {
+				log.Println("Account purge failed:", err)
+			} else if n > 0 {
+				log.Printf("Purged %d deleted accounts", n)
+			}
+			time.Sleep(purgeInterval)
+		}
+	}()
+}
+
+// purgeDeletedAccounts deletes up to purgeBatchSize due accounts and returns how many it deleted.
+// Several instances can run it at once: SKIP LOCKED hands each account to one of them.
+func purgeDeletedAccounts() (int, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+	SELECT user_id, deletion_requested_at FROM users
+	WHERE delete_after <= NOW()
+	ORDER BY delete_after
+	LIMIT $1
+	FOR UPDATE SKIP LOCKED
+`, purgeBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	type dueAccount struct {
+		userID      int
+		requestedAt sql.NullTime
+	}
+	var due []dueAccount
+	for rows.Next() {
+		var a dueAccount
+		if err := rows.Scan(&a.userID, &a.requestedAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		due = append(due, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, a := range due {
+		_, err = tx.Exec(`
+	INSERT INTO deleted_accounts (user_id, deletion_requested_at) VALUES ($1, $2)
+	ON CONFLICT (user_id) DO NOTHING
+`, a.userID, a.requestedAt)
+		if err == nil {
+			// Throttle rows are keyed by text rather than a foreign key
+			_, err = tx.Exec(`DELETE FROM login_throttles WHERE throttle_key = $1`, "user:"+strconv.Itoa(a.userID))
+		}
+		if err == nil {
+			_, err = tx.Exec(`DELETE FROM users WHERE user_id = $1`, a.userID)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(due), tx.Commit()
+}
+
+// warnUnpurgedTables logs tables with a user_id column that the cascade from users
+// would not clean up, so a new table that forgot ON DELETE CASCADE gets noticed.
+func warnUnpurgedTables() {
+	rows, err := database.DB.Query(`
+	SELECT DISTINCT col.table_name
+	FROM information_schema.columns col
+	WHERE col.table_schema = current_schema() AND col.column_name = 'user_id'
+		AND col.table_name NOT IN ('users', 'deleted_accounts')
+		AND NOT EXISTS (
+			SELECT 1 FROM pg_constraint con
+			WHERE con.contype = 'f' AND con.confrelid = 'users'::regclass
+				AND con.conrelid = (quote_ident(col.table_schema) || '.' || quote_ident(col.table_name))::regclass
+				AND con.confdeltype = 'c'
+		)
+	ORDER BY col.table_name
+`)
+	if err != nil {
+		log.Println("Failed to check user tables for the account purge:", err)
+		return
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err == nil {
+			tables = append(tables, table)
+		}
+	}
+	if len(tables) > 0 {
+		log.Printf("WARNING: account purge will not delete rows in %s (no user_id foreign key with ON DELETE CASCADE)",
+			strings.Join(tables, ", "))
+	}
+}
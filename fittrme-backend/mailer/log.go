@@ -0,0 +1,37 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogMailer writes messages to a file instead of sending them, for local development and tests.
+// With an empty Path, messages go to the standard logger.
+type LogMailer struct {
+	Path string
+}
+
+var logFileMu sync.Mutex
+
+func (m LogMailer) Send(msg Message) error {
+	entry := fmt.Sprintf("--- %s\nTo: %s\nSubject: %s\n\n%s\n\n",
+		time.Now().Format(time.RFC3339), msg.To, msg.Subject, msg.Body)
+
+	if m.Path == "" {
+		log.Print("mail not sent (log mailer):\n" + entry)
+		return nil
+	}
+
+	logFileMu.Lock()
+	defer logFileMu.Unlock()
+	f, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(entry)
+	return err
+}
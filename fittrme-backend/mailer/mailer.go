@@ -0,0 +1,44 @@
+// Package mailer sends transactional email (password resets, verification links, alerts).
+//
+// Handlers send through Default, which main configures from the environment:
+// MAILER=smtp delivers through an SMTP server, anything else writes messages to
+// a log file (or stdout) so local development and tests never send real email.
+package mailer
+
+import (
+	"os"
+)
+
+// Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers a Message.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Default is the mailer used by the handlers. It logs messages until Configure is called.
+var Default Mailer = LogMailer{}
+
+// Configure sets Default from the environment.
+//
+//	MAILER=smtp     SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD, MAIL_FROM
+//	MAILER=log      MAIL_LOG_FILE (optional, defaults to stdout)
+func Configure() {
+	switch os.Getenv("MAILER") {
+	case "smtp":
+		Default = SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}
+	default:
+		Default = LogMailer{Path: os.Getenv("MAIL_LOG_FILE")}
+	}
+}
@@ -0,0 +1,43 @@
+package mailer
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// SMTPMailer sends email through an SMTP server using PLAIN auth.
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m SMTPMailer) Send(msg Message) error {
+	if m.Host == "" || m.From == "" {
+		return fmt.Errorf("smtp mailer: SMTP_HOST and MAIL_FROM must be set")
+	}
+	port := m.Port
+	if port == "" {
+		port = "587"
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	// Build a minimal RFC 5322 message; header values must not contain line breaks.
+	header := strings.NewReplacer("\r", "", "\n", "")
+	body := "From: " + header.Replace(m.From) + "\r\n" +
+		"To: " + header.Replace(msg.To) + "\r\n" +
+		"Subject: " + header.Replace(msg.Subject) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + msg.Body
+
+	return smtp.SendMail(net.JoinHostPort(m.Host, port), auth, m.From, []string{msg.To}, []byte(body))
+}
@@ -3,8 +3,16 @@ package main
 import (
 	"fittrme-backend/database"
 	"fittrme-backend/handlers"
+	"fittrme-backend/jobs"
+	"fittrme-backend/mailer"
 	"fittrme-backend/middleware"
+	"fittrme-backend/models"
+	"fittrme-backend/revocation"
+	"fittrme-backend/utils"
+	"log"
 	"net/http"
+	"os"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,14 +21,53 @@ func main() {
 	// Step 1: Connect to PostgreSQL database
 	database.ConnectDB()
 
-	// Step 2: Initialize Gin router
-	router := gin.Default()
+	// Load the access token signing keys; refuse to start without them
+	if err := utils.LoadSigningKeys(); err != nil {
+		log.Fatal("Failed to load JWT signing keys:", err)
+	}
+
+	// Load the key login fingerprints are hashed with (new-device alerts)
+	if err := utils.LoadFingerprintKey(); err != nil {
+		log.Fatal("Failed to load login fingerprint key:", err)
+	}
+
+	// Load revoked access tokens and keep the cache in sync with other instances
+	if err := revocation.Start(); err != nil {
+		log.Fatal("Failed to start token revocation store:", err)
+	}
+
+	// Purge accounts whose deletion grace period has ended
+	jobs.StartAccountPurge()
+
+	// Build requested personal data exports
+	jobs.StartExportWorker()
+
+	// Remove deleted weigh-ins and body measurements once they can no longer be restored
+	jobs.StartEntryCleanup()
+
+	// Configure outgoing email (SMTP in production, log file locally)
+	mailer.Configure()
+
+	// Step 2: Initialize Gin router. gin.Default() would log request URLs in full,
+	// including the tokens of emailed links.
+	router := gin.New()
+	router.Use(middleware.Logger(), gin.Recovery())
+
+	// Client IPs feed login throttling, lockouts and the audit log, so X-Forwarded-For
+	// is only believed from the proxies in TRUSTED_PROXIES (comma-separated IPs or CIDRs,
+	// none by default). Behind a platform that sets its own client IP header (such as
+	// CF-Connecting-IP), name that header in TRUSTED_PLATFORM instead.
+	if err := router.SetTrustedProxies(trustedProxies()); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
+	router.TrustedPlatform = os.Getenv("TRUSTED_PLATFORM")
 
 	// Step 3: Handle CORS (for frontend access, like React Native app)
 	router.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Authorization")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Authorization, If-Match, Accept-Units")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "ETag")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(http.StatusNoContent)
@@ -29,6 +76,9 @@ func main() {
 		c.Next()
 	})
 
+	// Public signing keys, so other services can verify FittrMe access tokens
+	router.GET("/.well-known/jwks.json", handlers.JWKS)
+
 	// Step 4: Group all API routes under a common prefix
 	api := router.Group("/fittrme-api")
 
@@ -38,16 +88,109 @@ func main() {
 	})
 	api.POST("/register", handlers.SignupUser)
 	api.POST("/login", handlers.LoginUser)
+	api.POST("/login/mfa", handlers.LoginMFA)
+	api.GET("/login/oidc", handlers.ListOIDCProviders)
+	api.POST("/login/oidc/:provider/start", handlers.StartOIDCLogin)
+	api.POST("/login/oidc/:provider/callback", handlers.OIDCCallback)
+	api.POST("/login/passkey/options", handlers.BeginPasskeyLogin)
+	api.POST("/login/passkey", handlers.FinishPasskeyLogin)
+	api.POST("/refresh", handlers.RefreshToken)
+	api.POST("/password/forgot", handlers.ForgotPassword)
+	api.POST("/password/reset", handlers.ResetPassword)
+	api.GET("/verify-email", handlers.VerifyEmail)
+	api.POST("/verify-email/resend", handlers.ResendVerificationEmail)
+	api.GET("/me/email/confirm", handlers.ShowEmailChangeConfirmation)
+	api.POST("/me/email/confirm", handlers.ConfirmEmailChange)
+	api.GET("/me/email/undo", handlers.ShowEmailChangeUndo)
+	api.POST("/me/email/undo", handlers.UndoEmailChange)
+	api.GET("/exports/download", handlers.DownloadDataExport)
+	api.GET("/login-alerts/report", handlers.ReportLoginAlert)
+	api.POST("/login-alerts/report", handlers.ConfirmLoginAlertReport)
 
-	// Protected routes (authentication required)
+	// Protected routes (authentication required).
+	// Only routes that declare a scope with RequireScope accept personal access tokens.
 	protected := api.Group("/")
 	protected.Use(middleware.AuthRequired())
 	{
-		protected.GET("/weight", handlers.GetWeight)
-		protected.POST("/weight", handlers.SaveWeight)
+		protected.GET("/weight", middleware.RequireScope(models.ScopeWeightRead), handlers.GetWeight)
+		protected.GET("/weights", middleware.RequireScope(models.ScopeWeightRead), handlers.ListWeights)
+		protected.GET("/weights/trend", middleware.RequireScope(models.ScopeWeightRead), handlers.GetWeightTrend)
+		protected.GET("/weights/:id", middleware.RequireScope(models.ScopeWeightRead), handlers.GetWeightEntry)
+		protected.GET("/measurement-types", middleware.RequireScope(models.ScopeMeasurementsRead), handlers.ListMeasurementTypes)
+		protected.GET("/measurements", middleware.RequireScope(models.ScopeMeasurementsRead), handlers.ListMeasurements)
+		protected.GET("/measurements/latest", middleware.RequireScope(models.ScopeMeasurementsRead), handlers.GetLatestMeasurements)
+		protected.GET("/measurements/:id", middleware.RequireScope(models.ScopeMeasurementsRead), handlers.GetMeasurement)
 		protected.POST("/logout", handlers.LogoutUser)
+		protected.GET("/me", handlers.GetProfile)
+		protected.PATCH("/me", handlers.UpdateProfile)
+		protected.DELETE("/me", handlers.DeleteAccount)
+		protected.POST("/me/password", handlers.ChangePassword)
+		protected.POST("/me/email", handlers.RequestEmailChange)
+		protected.GET("/me/security-events", handlers.ListMySecurityEvents)
+		protected.GET("/me/login-alerts", handlers.ListLoginAlerts)
+		protected.POST("/me/login-alerts/:id/read", handlers.MarkLoginAlertRead)
+		protected.POST("/me/login-alerts/:id/report", handlers.ReportLoginAlertInApp)
+		protected.GET("/me/exports", handlers.ListDataExports)
+		protected.POST("/me/exports", handlers.RequestDataExport)
+		protected.GET("/me/exports/:id", handlers.GetDataExport)
+		protected.GET("/sessions", handlers.ListSessions)
+		protected.DELETE("/sessions/:id", handlers.RevokeSession)
+		protected.POST("/sessions/revoke-others", handlers.RevokeOtherSessions)
+		protected.POST("/mfa/totp/enroll", handlers.EnrollTOTP)
+		protected.POST("/mfa/totp/confirm", handlers.ConfirmTOTP)
+		protected.POST("/mfa/totp/disable", handlers.DisableTOTP)
+		protected.POST("/mfa/recovery-codes", handlers.RegenerateRecoveryCodes)
+		protected.GET("/passkeys", handlers.ListPasskeys)
+		protected.POST("/passkeys/options", handlers.BeginPasskeyRegistration)
+		protected.POST("/passkeys", handlers.FinishPasskeyRegistration)
+		protected.DELETE("/passkeys/:id", handlers.DeletePasskey)
+	}
+
+	// Protected routes that also need a verified email address
+	// (only reachable by unverified accounts under the "limit" policy)
+	verified := protected.Group("/")
+	verified.Use(middleware.RequireVerifiedEmail())
+	{
+		verified.POST("/weight", middleware.RequireScope(models.ScopeWeightWrite), handlers.SaveWeight)
+		verified.PUT("/weights/:id", middleware.RequireScope(models.ScopeWeightWrite), handlers.UpdateWeight)
+		verified.PATCH("/weights/:id", middleware.RequireScope(models.ScopeWeightWrite), handlers.PatchWeight)
+		verified.DELETE("/weights/:id", middleware.RequireScope(models.ScopeWeightWrite), handlers.DeleteWeight)
+		verified.POST("/weights/:id/restore", middleware.RequireScope(models.ScopeWeightWrite), handlers.RestoreWeight)
+		verified.POST("/measurements", middleware.RequireScope(models.ScopeMeasurementsWrite), handlers.SaveMeasurement)
+		verified.PUT("/measurements/:id", middleware.RequireScope(models.ScopeMeasurementsWrite), handlers.UpdateMeasurement)
+		verified.PATCH("/measurements/:id", middleware.RequireScope(models.ScopeMeasurementsWrite), handlers.PatchMeasurement)
+		verified.DELETE("/measurements/:id", middleware.RequireScope(models.ScopeMeasurementsWrite), handlers.DeleteMeasurement)
+		verified.POST("/measurements/:id/restore", middleware.RequireScope(models.ScopeMeasurementsWrite), handlers.RestoreMeasurement)
+		verified.GET("/api-tokens", handlers.ListAPITokens)
+		verified.POST("/api-tokens", handlers.CreateAPIToken)
+		verified.DELETE("/api-tokens/:id", handlers.RevokeAPIToken)
+	}
+
+	// Admin-only routes
+	admin := protected.Group("/admin")
+	admin.Use(middleware.RequireRole(models.RoleAdmin))
+	{
+		admin.GET("/security-events", handlers.ListSecurityEvents)
+		admin.POST("/measurement-types", handlers.SaveMeasurementType)
+		admin.GET("/users/:id/roles", handlers.ListUserRoles)
+		admin.POST("/users/:id/roles", handlers.GrantRole)
+		admin.DELETE("/users/:id/roles/:role", handlers.RevokeRole)
+		admin.POST("/users/:id/suspend", handlers.SuspendUser)
+		admin.POST("/users/:id/unsuspend", handlers.UnsuspendUser)
+		admin.POST("/users/:id/unlock", handlers.UnlockUser)
 	}
 
 	// Step 5: Start the server
 	router.Run(":8080")
 }
+
+// trustedProxies reads TRUSTED_PROXIES; nil trusts no proxy.
+func trustedProxies() []string {
+	var proxies []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	return proxies
+}
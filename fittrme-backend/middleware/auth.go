@@ -1,9 +1,9 @@
 package middleware
 
 import (
-	"fmt"
+	"fittrme-backend/revocation"
+	"fittrme-backend/utils"
 	"net/http"
-	"os"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -12,7 +12,8 @@ import (
 
 func AuthRequired() gin.HandlerFunc {
 	// AuthRequired is a middleware that protects private routes.
-	// It ensures that the incoming request includes a valid access token (JWT).
+	// It ensures that the incoming request includes a valid access token (JWT)
+	// or a personal access token (see RequireScope).
 
 	return func(c *gin.Context) {
 		// Step 1: Extract the "Authorization" header from the request.
@@ -25,18 +26,20 @@ func AuthRequired() gin.HandlerFunc {
 		}
 
 		// Step 2: Remove the "Bearer " prefix to isolate the actual JWT string.
+		// Personal access tokens are recognised by their prefix and validated separately.
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+		if strings.HasPrefix(tokenString, APITokenPrefix) {
+			authenticateAPIToken(c, tokenString)
+			return
+		}
 
-		// Step 3: Parse and validate the token using the JWT_ACCESS_SECRET from environment variables.
-		// If the token signature is invalid or the token has expired, return 401 Unauthorized.
-		secret := os.Getenv("JWT_ACCESS_SECRET")
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Make sure the signing method used is HMAC (HS256)
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method")
-			}
-			return []byte(secret), nil
-		})
+		// Step 3: Parse and validate the token against the public key named by its "kid" header.
+		// If the token signature is invalid, it was not issued by us or it has expired, return 401 Unauthorized.
+		token, err := jwt.Parse(tokenString, utils.VerificationKey,
+			jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg(), jwt.SigningMethodRS256.Alg()}),
+			jwt.WithIssuer(utils.TokenIssuer()),
+			jwt.WithExpirationRequired(),
+		)
 		if err != nil || !token.Valid {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
 			return
@@ -58,11 +61,39 @@ func AuthRequired() gin.HandlerFunc {
 			return
 		}
 
-		// Step 6: Store the userId in the Gin context so that downstream handlers can access it
-		// using c.Get("userId") — for example, to fetch the user's data from the database.
+		// Step 6: Every access token carries its own ID (jti) and the session it was issued for (sid).
+		// Reject the token if it, its session or all of the user's tokens have been revoked
+		// (logout, password change, suspension).
+		jti, _ := claims["jti"].(string)
+		sessionID, _ := claims["sid"].(string)
+		issuedAt, err := claims.GetIssuedAt()
+		if jti == "" || sessionID == "" || err != nil || issuedAt == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+			return
+		}
+		if revocation.IsRevoked(int(userId), sessionID, jti, issuedAt.Time) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+			return
+		}
+
+		// Step 7: Collect the user's roles for RequireRole. A token without the claim has no roles.
+		var roles []string
+		if rawRoles, ok := claims["roles"].([]interface{}); ok {
+			for _, r := range rawRoles {
+				if role, ok := r.(string); ok {
+					roles = append(roles, role)
+				}
+			}
+		}
+
+		// Step 8: Store the userId, roles, session ID and token ID in the Gin context so that downstream handlers
+		// can access them using c.Get("userId") — for example, to fetch the user's data from the database.
 		c.Set("userId", int(userId))
+		c.Set("roles", roles)
+		c.Set("sessionId", sessionID)
+		c.Set("tokenId", jti)
 
-		// Step 7: Allow the request to continue to the next handler in the middleware chain.
+		// Step 9: Allow the request to continue to the next handler in the middleware chain.
 		c.Next()
 	}
 }
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRole lets a request through only if the access token carries at least one of the given roles.
+// It must run after AuthRequired, which puts the token's roles in the context.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasAnyRole(c, roles...) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "You don't have permission to do this"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// HasAnyRole reports whether the authenticated user holds at least one of the given roles,
+// for handlers that need a finer-grained check than a whole route group.
+func HasAnyRole(c *gin.Context, roles ...string) bool {
+	value, _ := c.Get("roles")
+	held, _ := value.([]string)
+	for _, role := range roles {
+		if slices.Contains(held, role) {
+			return true
+		}
+	}
+	return false
+}
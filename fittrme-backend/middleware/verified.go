@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"database/sql"
+	"fittrme-backend/database"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireVerifiedEmail closes a route group to users who have not verified their email yet.
+// It must run after AuthRequired, which sets the user it checks.
+func RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := subjectUserID(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		// Check the database rather than a token claim, so access opens up
+		// as soon as the verification link is followed.
+		var verifiedAt sql.NullTime
+		err := database.DB.QueryRow(`SELECT email_verified_at FROM users WHERE user_id = $1`, userId).Scan(&verifiedAt)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if !verifiedAt.Valid {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Please verify your email address to use this feature"})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Scopes a personal access token can be granted.
+const (
+	ScopeWeightRead        = "weight:read"
+	ScopeWeightWrite       = "weight:write"
+	ScopeMeasurementsRead  = "measurements:read"
+	ScopeMeasurementsWrite = "measurements:write"
+)
+
+// APIToken is a personal access token as listed to its owner. The raw token is only
+// returned once, at creation; the database keeps its hash.
+type APIToken struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // first characters of the token, to tell tokens apart
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+type CreateAPITokenInput struct {
+	Name   string   `json:"name" binding:"required,max=100"`
+	Scopes []string `json:"scopes" binding:"required,min=1,dive,oneof=weight:read weight:write measurements:read measurements:write"`
+	// ExpiresInDays is optional; without it the token does not expire.
+	ExpiresInDays int `json:"expiresInDays" binding:"omitempty,min=1,max=3650"`
+}
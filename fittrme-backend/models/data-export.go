@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Data export statuses.
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+	ExportExpired = "expired"
+)
+
+// DataExport is a request for a zip archive of everything stored about a user.
+type DataExport struct {
+	ID          int        `json:"id"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	SizeBytes   *int64     `json:"sizeBytes"`
+	RequestedAt time.Time  `json:"requestedAt"`
+	CompletedAt *time.Time `json:"completedAt"`
+	ExpiresAt   *time.Time `json:"expiresAt"` // the download link stops working at this time
+}
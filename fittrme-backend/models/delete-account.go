@@ -0,0 +1,7 @@
+package models
+
+type DeleteAccountInput struct {
+	// Password is required for accounts that have one. Accounts without one must have
+	// signed in recently instead (see handlers.reauthenticate).
+	Password string `json:"password"`
+}
@@ -0,0 +1,8 @@
+package models
+
+type ChangeEmailInput struct {
+	NewEmail string `json:"newEmail" binding:"required,email"`
+	// Password is required for accounts that have one. Accounts without one must have
+	// signed in recently instead (see handlers.reauthenticate).
+	Password string `json:"password"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// LoginAlert is raised when a user signs in from a device or network they have not used before.
+type LoginAlert struct {
+	ID         int        `json:"id"`
+	SessionID  string     `json:"sessionId"`
+	DeviceName string     `json:"deviceName"`
+	IPAddress  string     `json:"ipAddress"`
+	UserAgent  string     `json:"userAgent"`
+	NewDevice  bool       `json:"newDevice"`
+	NewNetwork bool       `json:"newNetwork"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ReadAt     *time.Time `json:"readAt"`
+	ReportedAt *time.Time `json:"reportedAt"` // set once the user said "this wasn't me"
+}
@@ -3,5 +3,6 @@ package models
 type LoginInput struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
+	// DeviceName is an optional label (e.g. "Pixel 8") shown in the session list.
+	DeviceName string `json:"deviceName"`
 }
-
@@ -0,0 +1,67 @@
+package models
+
+import "time"
+
+// Units measurement types are stored in. Values are always kept in these units.
+const (
+	UnitPercent = "percent"
+	UnitKg      = "kg"
+	UnitCm      = "cm"
+)
+
+// Imperial units, accepted in requests and shown to users who prefer imperial units.
+const (
+	UnitLb   = "lb"
+	UnitInch = "in"
+)
+
+// MeasurementType is an entry of the measurement type registry (body fat, waist, ...).
+type MeasurementType struct {
+	Key      string  `json:"key"` // e.g. "waist"
+	Name     string  `json:"name"`
+	Unit     string  `json:"unit"`
+	MinValue float64 `json:"minValue"` // values outside [MinValue, MaxValue] are rejected as typos
+	MaxValue float64 `json:"maxValue"`
+}
+
+// Measurement is one reading of a measurement type, like Weight is one weigh-in.
+type Measurement struct {
+	ID         int64      `json:"id"`
+	UserID     int        `json:"userId"`
+	Type       string     `json:"type"`
+	Value      float64    `json:"value"`
+	Unit       string     `json:"unit"` // the type's unit, or its imperial counterpart in imperial responses
+	MeasuredAt *time.Time `json:"measured_at"`
+	Version    int        `json:"version"`
+}
+
+type SaveMeasurementInput struct {
+	Type       string     `json:"type" binding:"required"`
+	Value      float64    `json:"value" binding:"required,gt=0"`
+	Unit       string     `json:"unit" binding:"omitempty,oneof=percent kg lb cm in"` // defaults to the type's unit
+	MeasuredAt *time.Time `json:"measured_at"`                                        // defaults to the time of the request
+}
+
+// UpdateMeasurementInput replaces a measurement (PUT /measurements/:id); see UpdateWeightInput for Version.
+type UpdateMeasurementInput struct {
+	Value      float64    `json:"value" binding:"required,gt=0"`
+	Unit       string     `json:"unit" binding:"omitempty,oneof=percent kg lb cm in"`
+	MeasuredAt *time.Time `json:"measured_at" binding:"required"`
+	Version    *int       `json:"version"`
+}
+
+// PatchMeasurementInput is a partial update (PATCH /measurements/:id).
+type PatchMeasurementInput struct {
+	Value      *float64   `json:"value" binding:"omitempty,gt=0"`
+	Unit       string     `json:"unit" binding:"omitempty,oneof=percent kg lb cm in"` // unit of value
+	MeasuredAt *time.Time `json:"measured_at"`
+	Version    *int       `json:"version"`
+}
+
+type MeasurementTypeInput struct {
+	Key      string  `json:"key" binding:"required,max=40"` // lower case letters, digits and underscores
+	Name     string  `json:"name" binding:"required,max=100"`
+	Unit     string  `json:"unit" binding:"required,oneof=percent kg cm"`
+	MinValue float64 `json:"minValue" binding:"gte=0"`
+	MaxValue float64 `json:"maxValue" binding:"required,gtfield=MinValue"`
+}
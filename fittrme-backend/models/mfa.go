@@ -0,0 +1,16 @@
+package models
+
+type MFACodeInput struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type MFALoginInput struct {
+	MFAToken string `json:"mfaToken" binding:"required"`
+	// Code is either a current TOTP code or one of the user's recovery codes.
+	Code string `json:"code" binding:"required"`
+}
+
+type DisableMFAInput struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
@@ -0,0 +1,12 @@
+package models
+
+type OIDCStartInput struct {
+	// DeviceName is an optional label (e.g. "Pixel 8") shown in the session list.
+	DeviceName string `json:"deviceName"`
+}
+
+// OIDCCallbackInput carries the parameters the provider appended to the redirect URL.
+type OIDCCallbackInput struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+// Passkey is a WebAuthn credential as listed to its owner.
+type Passkey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Transports []string   `json:"transports"` // e.g. "internal", "hybrid", "usb"
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
+
+// The credential inputs follow PublicKeyCredential.toJSON(): binary values are Base64URL strings.
+
+type AttestationResponse struct {
+	ClientDataJSON    string   `json:"clientDataJSON" binding:"required"`
+	AttestationObject string   `json:"attestationObject" binding:"required"`
+	Transports        []string `json:"transports"`
+}
+
+type AttestationCredential struct {
+	ID       string              `json:"id" binding:"required"`
+	Response AttestationResponse `json:"response" binding:"required"`
+}
+
+type PasskeyRegistrationInput struct {
+	ChallengeID string                `json:"challengeId" binding:"required"`
+	Name        string                `json:"name" binding:"max=100"`
+	Credential  AttestationCredential `json:"credential" binding:"required"`
+}
+
+type AssertionResponse struct {
+	ClientDataJSON    string `json:"clientDataJSON" binding:"required"`
+	AuthenticatorData string `json:"authenticatorData" binding:"required"`
+	Signature         string `json:"signature" binding:"required"`
+	UserHandle        string `json:"userHandle"`
+}
+
+type AssertionCredential struct {
+	ID       string            `json:"id" binding:"required"`
+	Response AssertionResponse `json:"response" binding:"required"`
+}
+
+type PasskeyLoginInput struct {
+	ChallengeID string              `json:"challengeId" binding:"required"`
+	Credential  AssertionCredential `json:"credential" binding:"required"`
+	// DeviceName is an optional label (e.g. "Pixel 8") shown in the session list.
+	DeviceName string `json:"deviceName"`
+}
@@ -0,0 +1,18 @@
+package models
+
+// UpdateProfileInput is a partial update: only the fields present in the request are changed.
+type UpdateProfileInput struct {
+	Username      *string `json:"username"` // trimmed, then checked by normalizeUsername
+	DisplayName   *string `json:"displayName" binding:"omitempty,max=100"`
+	DateOfBirth   *string `json:"dateOfBirth" binding:"omitempty,datetime=2006-01-02"`
+	Sex           *string `json:"sex" binding:"omitempty,oneof=female male other"`
+	ActivityLevel *string `json:"activityLevel" binding:"omitempty,oneof=sedentary light moderate active very_active"`
+	Timezone      *string `json:"timezone" binding:"omitempty,timezone"`
+	Locale        *string `json:"locale" binding:"omitempty,bcp47_language_tag"`
+	Units         *string `json:"units" binding:"omitempty,oneof=metric imperial"`
+}
+
+type ChangePasswordInput struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required,max=256"` // see utils.CheckPassword
+}
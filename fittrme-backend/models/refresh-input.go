@@ -0,0 +1,5 @@
+package models
+
+type RefreshInput struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
@@ -5,6 +5,7 @@ import "time"
 type RefreshToken struct {
 	ID        int       `json:"id"`
 	UserID    int       `json:"userId"`
+	FamilyID  string    `json:"familyId"`
 	TokenHash string    `json:"tokenHash"`
 	ExpiresAt time.Time `json:"expiresAt"`
 	Revoked   bool      `json:"revoked"`
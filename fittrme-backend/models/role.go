@@ -0,0 +1,12 @@
+package models
+
+// Roles a user can hold. Every account gets RoleMember at signup.
+const (
+	RoleMember = "member"
+	RoleCoach  = "coach"
+	RoleAdmin  = "admin"
+)
+
+type RoleInput struct {
+	Role string `json:"role" binding:"required,oneof=member coach admin"`
+}
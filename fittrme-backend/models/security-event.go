@@ -0,0 +1,47 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Security event types recorded in the audit log.
+const (
+	EventSignup                 = "signup"
+	EventLoginSucceeded         = "login.succeeded"
+	EventLoginFailed            = "login.failed"
+	EventLogout                 = "logout"
+	EventTokenRefreshed         = "token.refreshed"
+	EventTokenReuseDetected     = "token.reuse_detected"
+	EventSessionRevoked         = "session.revoked"
+	EventOtherSessionsRevoked   = "session.revoked_others"
+	EventPasswordChanged        = "password.changed"
+	EventPasswordResetRequested = "password.reset_requested"
+	EventPasswordReset          = "password.reset"
+	EventEmailVerified          = "email.verified"
+	EventEmailChangeRequested   = "email.change_requested"
+	EventEmailChanged           = "email.changed"
+	EventEmailChangeUndone      = "email.change_undone"
+	EventMFAEnabled             = "mfa.enabled"
+	EventMFADisabled            = "mfa.disabled"
+	EventMFAFailed              = "mfa.failed"
+	EventRecoveryCodeUsed       = "mfa.recovery_code_used"
+	EventRecoveryCodesReplaced  = "mfa.recovery_codes_regenerated"
+	EventPasskeyAdded           = "passkey.added"
+	EventPasskeyRemoved         = "passkey.removed"
+	EventAccountDeletion        = "account.deletion_requested"
+	EventNewDeviceLogin         = "login.new_device"
+	EventLoginReported          = "login.reported"
+)
+
+// SecurityEvent is one entry of the append-only audit log.
+type SecurityEvent struct {
+	ID        int64           `json:"id"`
+	UserID    *int            `json:"userId"` // nil for failed logins with an unknown username
+	Type      string          `json:"type"`
+	IPAddress string          `json:"ipAddress"`
+	UserAgent string          `json:"userAgent"`
+	SessionID *string         `json:"sessionId"`
+	Details   json.RawMessage `json:"details"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Session is one signed-in device. Its ID is shared by every refresh token
+// rotated out of the login that created it (refresh_tokens.family_id).
+type Session struct {
+	ID         string     `json:"id"`
+	UserID     int        `json:"userId"`
+	DeviceName string     `json:"deviceName"`
+	UserAgent  string     `json:"userAgent"`
+	IPAddress  string     `json:"ipAddress"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt time.Time  `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	// Current is true for the session the request was made from.
+	Current bool `json:"current"`
+}
@@ -3,5 +3,5 @@ package models
 type SignupInput struct {
 	Email    string `json:"email" binding:"required,email"`
 	Username string `json:"username" binding:"required"`
-	Password string `json:"password" binding:"required,min=6"`
+	Password string `json:"password" binding:"required,max=256"` // strength is checked by utils.CheckPassword
 }
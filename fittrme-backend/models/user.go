@@ -2,10 +2,29 @@ package models
 
 import "time"
 
+// Unit systems a user can prefer (users.units)
+const (
+	UnitsMetric   = "metric"
+	UnitsImperial = "imperial"
+)
+
 type User struct {
-	UserID       int       `json:"userId" db:"user_id"`
-	Username     string    `json:"username" db:"username"`
-	Email        string    `json:"email" db:"email"`
-	PasswordHash string    `json:"-" db:"password_hash"` // never exposed in API
-	CreatedAt    time.Time `json:"createdAt" db:"created_at"`
+	UserID          int        `json:"userId" db:"user_id"`
+	Username        string     `json:"username" db:"username"`
+	Email           string     `json:"email" db:"email"`
+	PasswordHash    string     `json:"-" db:"password_hash"` // never exposed in API
+	CreatedAt       time.Time  `json:"createdAt" db:"created_at"`
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt" db:"email_verified_at"`  // nil until the email is verified
+	SuspendedAt     *time.Time `json:"suspendedAt,omitempty" db:"suspended_at"` // set by an admin; blocks every login
+	DisplayName     string     `json:"displayName" db:"display_name"`
+	DateOfBirth     *string    `json:"dateOfBirth" db:"date_of_birth"` // YYYY-MM-DD
+	Sex             *string    `json:"sex" db:"sex"`
+	ActivityLevel   *string    `json:"activityLevel" db:"activity_level"`
+	Timezone        string     `json:"timezone" db:"timezone"`                  // IANA name, e.g. "Europe/Berlin"
+	Locale          string     `json:"locale" db:"locale"`                      // BCP 47 tag, e.g. "en-GB"
+	Units           string     `json:"units" db:"units"`                        // "metric" or "imperial"
+	DeleteAfter     *time.Time `json:"deleteAfter,omitempty" db:"delete_after"` // set by DELETE /me; logging in cancels it
+	// PasswordResetRequiredAt blocks password logins until the password is reset
+	// (set when a new-device login alert is reported as "this wasn't me").
+	PasswordResetRequiredAt *time.Time `json:"passwordResetRequired,omitempty" db:"password_reset_required_at"`
 }
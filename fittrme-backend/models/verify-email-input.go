@@ -0,0 +1,5 @@
+package models
+
+type ResendVerificationInput struct {
+	Email string `json:"email" binding:"required,email"`
+}
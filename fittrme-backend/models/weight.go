@@ -1,8 +1,43 @@
 package models
 
+import "time"
+
+// Weight is one weigh-in. Every measurement is its own row; the latest one by
+// MeasuredAt is the user's current weight.
+//
+// Weights are stored in kg and heights in cm. Requests may send them in lb and in by
+// setting Unit and HeightUnit; responses use the user's preferred unit system.
 type Weight struct {
-	UserID        int     `json:"userId"`
-	CurrentWeight float64 `json:"currentWeight"`
-	TargetWeight  float64 `json:"targetWeight"`
-	Height        float64 `json:"height"`
+	ID            int64      `json:"id"`
+	UserID        int        `json:"userId"`
+	CurrentWeight float64    `json:"currentWeight"`
+	TargetWeight  float64    `json:"targetWeight"`
+	Unit          string     `json:"unit" binding:"omitempty,oneof=kg lb"` // of CurrentWeight and TargetWeight; defaults to kg
+	Height        float64    `json:"height"`
+	HeightUnit    string     `json:"heightUnit" binding:"omitempty,oneof=cm in"` // defaults to cm
+	MeasuredAt    *time.Time `json:"measured_at"`                                // supplied by the client; defaults to the time of the request
+	Version       int        `json:"version"`                                    // increases with every edit; see UpdateWeightInput
+}
+
+// UpdateWeightInput replaces a weigh-in (PUT /weights/:id). Version must match the
+// stored one, unless the request sends it as an If-Match ETag instead.
+type UpdateWeightInput struct {
+	CurrentWeight float64    `json:"currentWeight" binding:"required,gt=0"`
+	TargetWeight  float64    `json:"targetWeight" binding:"required,gt=0"`
+	Unit          string     `json:"unit" binding:"omitempty,oneof=kg lb"`
+	Height        float64    `json:"height" binding:"required,gt=0"`
+	HeightUnit    string     `json:"heightUnit" binding:"omitempty,oneof=cm in"`
+	MeasuredAt    *time.Time `json:"measured_at" binding:"required"`
+	Version       *int       `json:"version"`
+}
+
+// PatchWeightInput is a partial update (PATCH /weights/:id): only the fields present are changed.
+type PatchWeightInput struct {
+	CurrentWeight *float64   `json:"currentWeight" binding:"omitempty,gt=0"`
+	TargetWeight  *float64   `json:"targetWeight" binding:"omitempty,gt=0"`
+	Unit          string     `json:"unit" binding:"omitempty,oneof=kg lb"` // of the weights sent
+	Height        *float64   `json:"height" binding:"omitempty,gt=0"`
+	HeightUnit    string     `json:"heightUnit" binding:"omitempty,oneof=cm in"`
+	MeasuredAt    *time.Time `json:"measured_at"`
+	Version       *int       `json:"version"`
 }
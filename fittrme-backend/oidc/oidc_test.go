@@ -0,0 +1,320 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeIssuer is a local OpenID provider: discovery, JWKS and a token endpoint that
+// checks PKCE and returns whatever ID token claims the test sets.
+type fakeIssuer struct {
+	srv *httptest.Server
+
+	mu         sync.Mutex
+	keys       map[string]*ecdsa.PrivateKey // published in the JWKS
+	signingKid string
+	forgeKey   *ecdsa.PrivateKey // if set, signs ID tokens instead of the signingKid key
+	challenges map[string]string // authorization code -> PKCE code_challenge
+	claims     jwt.MapClaims     // ID token payload for the next exchange
+	jwksHits   int
+	issuer     string // issuer in the discovery document, if not the server URL
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+	f := &fakeIssuer{keys: map[string]*ecdsa.PrivateKey{}, challenges: map[string]string{}}
+	f.rotateKey(t, "key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		issuer := f.srv.URL
+		if f.issuer != "" {
+			issuer = f.issuer
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                issuer,
+			"authorization_endpoint":                f.srv.URL + "/authorize",
+			"token_endpoint":                        f.srv.URL + "/token",
+			"jwks_uri":                              f.srv.URL + "/jwks",
+			"token_endpoint_auth_methods_supported": []string{"client_secret_basic"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.jwksHits++
+		var keys []map[string]string
+		for kid, key := range f.keys {
+			keys = append(keys, map[string]string{
+				"kty": "EC", "crv": "P-256", "use": "sig", "kid": kid,
+				"x": base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+				"y": base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		clientID, secret, ok := r.BasicAuth()
+		if !ok || clientID != "fittrme" || secret != "s3cret" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		challenge, known := f.challenges[r.PostFormValue("code")]
+		if !known || CodeChallenge(r.PostFormValue("code_verifier")) != challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, f.claims)
+		token.Header["kid"] = f.signingKid
+		key := f.keys[f.signingKid]
+		if f.forgeKey != nil {
+			key = f.forgeKey
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+	})
+	f.srv = httptest.NewServer(mux)
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+// rotateKey publishes a new signing key and signs further ID tokens with it.
+func (f *fakeIssuer) rotateKey(t *testing.T, kid string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.keys[kid] = key
+	f.signingKid = kid
+}
+
+func (f *fakeIssuer) provider() *Provider {
+	return &Provider{
+		Name:         "fake",
+		Issuer:       f.srv.URL,
+		ClientID:     "fittrme",
+		ClientSecret: "s3cret",
+		RedirectURL:  "fittrme://oidc/callback",
+	}
+}
+
+// authorize plays the user signing in: it takes the authorization URL and returns a
+// code bound to its PKCE challenge, with an ID token for claims.
+func (f *fakeIssuer) authorize(t *testing.T, authURL string, claims jwt.MapClaims) string {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Query().Get("code_challenge_method") != "S256" {
+		t.Fatalf("code_challenge_method = %q", u.Query().Get("code_challenge_method"))
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	code := "code-" + u.Query().Get("state")
+	f.challenges[code] = u.Query().Get("code_challenge")
+	f.claims = claims
+	return code
+}
+
+func (f *fakeIssuer) idClaims(nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            f.srv.URL,
+		"aud":            "fittrme",
+		"sub":            "user-123",
+		"email":          "jane@example.com",
+		"email_verified": true,
+		"nonce":          nonce,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+	}
+}
+
+// login runs AuthCodeURL, the provider's sign-in and Exchange.
+func login(t *testing.T, f *fakeIssuer, p *Provider, edit func(jwt.MapClaims)) (*Claims, error) {
+	t.Helper()
+	ctx := context.Background()
+	authURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-with-enough-entropy-0123456789")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	claims := f.idClaims("nonce-1")
+	if edit != nil {
+		edit(claims)
+	}
+	code := f.authorize(t, authURL, claims)
+	return p.Exchange(ctx, code, "verifier-with-enough-entropy-0123456789", "nonce-1")
+}
+
+func TestLogin(t *testing.T) {
+	f := newFakeIssuer(t)
+	claims, err := login(t, f, f.provider(), nil)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if claims.Subject != "user-123" || claims.Email != "jane@example.com" || !claims.EmailVerified {
+		t.Fatalf("claims = %+v", claims)
+	}
+
+	// Some providers send email_verified as a string
+	claims, err = login(t, f, f.provider(), func(c jwt.MapClaims) { c["email_verified"] = "true" })
+	if err != nil || !claims.EmailVerified {
+		t.Fatalf("string email_verified: claims = %+v, err = %v", claims, err)
+	}
+	claims, err = login(t, f, f.provider(), func(c jwt.MapClaims) { delete(c, "email_verified") })
+	if err != nil || claims.EmailVerified {
+		t.Fatalf("missing email_verified: claims = %+v, err = %v", claims, err)
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	f := newFakeIssuer(t)
+	authURL, err := f.provider().AuthCodeURL(context.Background(), "the-state", "the-nonce", "the-verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(authURL)
+	q := u.Query()
+	if !strings.HasPrefix(authURL, f.srv.URL+"/authorize?") {
+		t.Errorf("authorization endpoint = %s", authURL)
+	}
+	for param, want := range map[string]string{
+		"response_type":  "code",
+		"client_id":      "fittrme",
+		"redirect_uri":   "fittrme://oidc/callback",
+		"state":          "the-state",
+		"nonce":          "the-nonce",
+		"code_challenge": CodeChallenge("the-verifier"),
+	} {
+		if got := q.Get(param); got != want {
+			t.Errorf("%s = %q, want %q", param, got, want)
+		}
+	}
+	if !strings.Contains(q.Get("scope"), "openid") {
+		t.Errorf("scope = %q", q.Get("scope"))
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// RFC 7636 appendix B
+	got := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Fatalf("CodeChallenge = %s, want %s", got, want)
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	f := newFakeIssuer(t)
+	f.issuer = "https://evil.example"
+	if _, err := f.provider().AuthCodeURL(context.Background(), "s", "n", "v"); err == nil ||
+		!strings.Contains(err.Error(), "issuer mismatch") {
+		t.Fatalf("err = %v, want issuer mismatch", err)
+	}
+}
+
+func TestExchangeWrongVerifier(t *testing.T) {
+	f := newFakeIssuer(t)
+	p := f.provider()
+	authURL, err := p.AuthCodeURL(context.Background(), "s", "nonce-1", "the-real-verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := f.authorize(t, authURL, f.idClaims("nonce-1"))
+	if _, err := p.Exchange(context.Background(), code, "a-guessed-verifier", "nonce-1"); err == nil {
+		t.Fatal("exchange with the wrong PKCE verifier succeeded")
+	}
+}
+
+func TestIDTokenRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(jwt.MapClaims)
+		want string
+	}{
+		{"nonce mismatch", func(c jwt.MapClaims) { c["nonce"] = "replayed" }, "nonce"},
+		{"missing nonce", func(c jwt.MapClaims) { delete(c, "nonce") }, "nonce"},
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "someone-else" }, "aud"},
+		{"several audiences without azp", func(c jwt.MapClaims) { c["aud"] = []string{"fittrme", "other"} }, "azp"},
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }, "iss"},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, "expired"},
+		{"no expiry", func(c jwt.MapClaims) { delete(c, "exp") }, "exp"},
+		{"missing subject", func(c jwt.MapClaims) { delete(c, "sub") }, "sub"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeIssuer(t)
+			_, err := login(t, f, f.provider(), tt.edit)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want one mentioning %q", err, tt.want)
+			}
+		})
+	}
+
+	t.Run("several audiences with azp", func(t *testing.T) {
+		f := newFakeIssuer(t)
+		_, err := login(t, f, f.provider(), func(c jwt.MapClaims) {
+			c["aud"] = []string{"fittrme", "other"}
+			c["azp"] = "fittrme"
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestSigningKeyRotation(t *testing.T) {
+	f := newFakeIssuer(t)
+	p := f.provider()
+	if _, err := login(t, f, p, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A token signed with a new key makes the cache fetch the JWKS again...
+	f.rotateKey(t, "key-2")
+	p.keys.fetchedAt = time.Now().Add(-2 * minKeyRefetchInterval)
+	if _, err := login(t, f, p, nil); err != nil {
+		t.Fatalf("after rotation: %v", err)
+	}
+	if f.jwksHits != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2", f.jwksHits)
+	}
+
+	// ...but not more than once per minKeyRefetchInterval
+	f.rotateKey(t, "key-3")
+	if _, err := login(t, f, p, nil); err == nil || !strings.Contains(err.Error(), "unknown signing key") {
+		t.Fatalf("err = %v, want unknown signing key", err)
+	}
+	if f.jwksHits != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2", f.jwksHits)
+	}
+
+	// A token naming a known kid but signed with another key is rejected
+	f.signingKid = "key-2"
+	f.forgeKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if _, err := login(t, f, p, nil); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Fatalf("err = %v, want invalid signature", err)
+	}
+}
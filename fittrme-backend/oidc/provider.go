@@ -0,0 +1,241 @@
+// Package oidc implements the relying-party side of OpenID Connect login
+// (authorization code flow with PKCE) against any provider that supports discovery.
+//
+// Providers are configured from the environment:
+//
+//	OIDC_PROVIDERS                 comma-separated provider names, e.g. "google,apple"
+//	OIDC_<NAME>_ISSUER             issuer URL; <issuer>/.well-known/openid-configuration is fetched
+//	OIDC_<NAME>_CLIENT_ID
+//	OIDC_<NAME>_CLIENT_SECRET
+//	OIDC_<NAME>_REDIRECT_URL       where the provider sends the user back (usually an app deep link)
+//
+// Any issuer works, including a local fake provider on http://localhost for tests.
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPClient is used for every request to a provider. Tests can replace it.
+var HTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// ErrUnknownProvider is returned by Lookup for a provider that is not configured.
+var ErrUnknownProvider = errors.New("unknown OIDC provider")
+
+// Provider is one configured OpenID Connect issuer.
+type Provider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+
+	mu        sync.Mutex
+	discovery *discoveryDocument
+	keys      *keyCache
+}
+
+// discoveryDocument holds the fields we use from /.well-known/openid-configuration.
+type discoveryDocument struct {
+	Issuer                   string   `json:"issuer"`
+	AuthorizationEndpoint    string   `json:"authorization_endpoint"`
+	TokenEndpoint            string   `json:"token_endpoint"`
+	JWKSURI                  string   `json:"jwks_uri"`
+	TokenEndpointAuthMethods []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+var (
+	providersOnce sync.Once
+	providers     map[string]*Provider
+)
+
+// loadProviders reads the provider configuration from the environment once.
+func loadProviders() {
+	providers = map[string]*Provider{}
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		p := &Provider{
+			Name:         name,
+			Issuer:       strings.TrimSuffix(os.Getenv(prefix+"ISSUER"), "/"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+		}
+		if p.Issuer == "" || p.ClientID == "" || p.RedirectURL == "" {
+			continue
+		}
+		providers[name] = p
+	}
+}
+
+// Lookup returns the configured provider with the given name.
+func Lookup(name string) (*Provider, error) {
+	providersOnce.Do(loadProviders)
+	p, ok := providers[strings.ToLower(name)]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return p, nil
+}
+
+// Names returns the names of all configured providers.
+func Names() []string {
+	providersOnce.Do(loadProviders)
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// discover fetches and caches the provider's discovery document.
+func (p *Provider) discover(ctx context.Context) (*discoveryDocument, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var doc discoveryDocument
+	if err := getJSON(ctx, p.Issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, fmt.Errorf("oidc discovery for %s: %w", p.Name, err)
+	}
+	// The issuer in the document must be exactly the one we were configured with
+	if strings.TrimSuffix(doc.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("oidc discovery for %s: issuer mismatch %q", p.Name, doc.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc discovery for %s: incomplete document", p.Name)
+	}
+
+	p.discovery = &doc
+	p.keys = newKeyCache(doc.JWKSURI)
+	return p.discovery, nil
+}
+
+// AuthCodeURL builds the authorization request URL the user is sent to.
+// state and nonce bind the response to this login attempt; codeVerifier is the PKCE secret.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", p.RedirectURL)
+	q.Set("scope", "openid email profile")
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", CodeChallenge(codeVerifier))
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(doc.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return doc.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// CodeChallenge derives the PKCE S256 challenge for a verifier.
+func CodeChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Exchange trades an authorization code for tokens and returns the verified ID token claims.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Claims, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+
+	// client_secret_basic is the default auth method; fall back to client_secret_post
+	// for providers that only advertise that.
+	useBasic := p.ClientSecret != "" && (len(doc.TokenEndpointAuthMethods) == 0 ||
+		slices.Contains(doc.TokenEndpointAuthMethods, "client_secret_basic"))
+	if !useBasic {
+		form.Set("client_id", p.ClientID)
+		if p.ClientSecret != "" {
+			form.Set("client_secret", p.ClientSecret)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if useBasic {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned %d: %s", resp.StatusCode, body)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	return p.verifyIDToken(ctx, tokens.IDToken, nonce)
+}
+
+// getJSON fetches url and decodes the JSON body into v.
+func getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
@@ -0,0 +1,212 @@
+// Package revocation keeps track of access tokens that must stop working before they expire.
+//
+// Revocations are written to the token_revocations table and mirrored in an in-process cache,
+// so AuthRequired can check every request without a database round trip. Each write also sends
+// a Postgres NOTIFY, which every server instance listens for to update its own cache right away.
+// The cache is reloaded from the table periodically and after a listener reconnect, in case a
+// notification was missed.
+package revocation
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fittrme-backend/database"
+	"fittrme-backend/utils"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const notifyChannel = "token_revocations"
+
+// reloadInterval is how often the cache is rebuilt from the table as a safety net.
+const reloadInterval = time.Minute
+
+// Execer is satisfied by both *sql.DB and *sql.Tx, so a revocation can be
+// written as part of a caller's transaction (the NOTIFY is then sent on commit).
+type Execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// entry is one row of token_revocations. Exactly one of JTI, SessionID or UserID is set.
+type entry struct {
+	JTI       string    `json:"jti,omitempty"`
+	SessionID string    `json:"sessionId,omitempty"`
+	UserID    int       `json:"userId,omitempty"`
+	RevokedAt time.Time `json:"revokedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type cache struct {
+	mu       sync.RWMutex
+	jtis     map[string]time.Time // jti -> entry expiry
+	sessions map[string]time.Time // session ID -> entry expiry
+	users    map[int]entry        // user ID -> latest user-wide revocation
+}
+
+var store = newCache()
+
+func newCache() *cache {
+	return &cache{
+		jtis:     map[string]time.Time{},
+		sessions: map[string]time.Time{},
+		users:    map[int]entry{},
+	}
+}
+
+func (c *cache) add(e entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case e.JTI != "":
+		c.jtis[e.JTI] = e.ExpiresAt
+	case e.SessionID != "":
+		c.sessions[e.SessionID] = e.ExpiresAt
+	case e.UserID != 0:
+		if cur, ok := c.users[e.UserID]; !ok || e.RevokedAt.After(cur.RevokedAt) {
+			c.users[e.UserID] = e
+		}
+	}
+}
+
+// Start loads the active revocations into memory and keeps the cache in sync
+// with other instances. It must be called after database.ConnectDB.
+func Start() error {
+	if err := reload(); err != nil {
+		return err
+	}
+
+	listener := pq.NewListener(database.ConnectionString(), 10*time.Second, time.Minute,
+		func(ev pq.ListenerEventType, err error) {
+			if err != nil {
+				log.Println("revocation listener:", err)
+			}
+		})
+	if err := listener.Listen(notifyChannel); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(reloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case n := <-listener.Notify:
+				// A nil notification means the connection was re-established
+				// and notifications may have been lost in between.
+				if n == nil {
+					if err := reload(); err != nil {
+						log.Println("revocation reload:", err)
+					}
+					continue
+				}
+				var e entry
+				if err := json.Unmarshal([]byte(n.Extra), &e); err != nil {
+					log.Println("revocation notification:", err)
+					continue
+				}
+				store.add(e)
+			case <-ticker.C:
+				if err := reload(); err != nil {
+					log.Println("revocation reload:", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// reload rebuilds the cache from every unexpired row and deletes expired ones.
+func reload() error {
+	if _, err := database.DB.Exec(`DELETE FROM token_revocations WHERE expires_at < NOW()`); err != nil {
+		return err
+	}
+
+	rows, err := database.DB.Query(`
+	SELECT COALESCE(jti, ''), COALESCE(session_id, ''), COALESCE(user_id, 0), revoked_at, expires_at
+	FROM token_revocations
+`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	fresh := newCache()
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.JTI, &e.SessionID, &e.UserID, &e.RevokedAt, &e.ExpiresAt); err != nil {
+			return err
+		}
+		fresh.add(e)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	store.jtis, store.sessions, store.users = fresh.jtis, fresh.sessions, fresh.users
+	store.mu.Unlock()
+	return nil
+}
+
+// RevokeToken revokes a single access token by its jti until it would have expired anyway.
+func RevokeToken(db Execer, jti string, expiresAt time.Time) error {
+	return write(db, entry{JTI: jti, ExpiresAt: expiresAt})
+}
+
+// RevokeSession revokes every access token issued for a session.
+func RevokeSession(db Execer, sessionID string) error {
+	return write(db, entry{SessionID: sessionID, ExpiresAt: time.Now().Add(utils.AccessTokenTTL())})
+}
+
+// RevokeUser revokes every access token issued to a user up to now, on all sessions.
+func RevokeUser(db Execer, userID int) error {
+	return write(db, entry{UserID: userID, ExpiresAt: time.Now().Add(utils.AccessTokenTTL())})
+}
+
+// write persists a revocation, notifies the other instances and updates the local cache.
+// Entries only need to live as long as the tokens they revoke, so ExpiresAt is the
+// latest expiry of any token the entry can match.
+func write(db Execer, e entry) error {
+	err := db.QueryRow(`
+	INSERT INTO token_revocations (jti, session_id, user_id, expires_at)
+	VALUES (NULLIF($1, ''), NULLIF($2, ''), NULLIF($3, 0), $4)
+	RETURNING revoked_at
+`, e.JTI, e.SessionID, e.UserID, e.ExpiresAt).Scan(&e.RevokedAt)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(`SELECT pg_notify($1, $2)`, notifyChannel, string(payload)); err != nil {
+		return err
+	}
+
+	store.add(e)
+	return nil
+}
+
+// IsRevoked reports whether an access token with the given claims has been revoked.
+func IsRevoked(userID int, sessionID, jti string, issuedAt time.Time) bool {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	if _, ok := store.jtis[jti]; ok {
+		return true
+	}
+	if _, ok := store.sessions[sessionID]; ok {
+		return true
+	}
+	// iat has one-second precision, so a token issued in the same second as a
+	// user-wide revocation is treated as revoked.
+	if e, ok := store.users[userID]; ok && !issuedAt.After(e.RevokedAt.Truncate(time.Second)) {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,21 @@
+package utils
+
+// Body composition values derived from measurements.
+
+// WaistToHipRatio divides the waist by the hip circumference (same unit).
+func WaistToHipRatio(waist, hip float64) float64 {
+	return waist / hip
+}
+
+// LeanMassFromBodyFat estimates fat-free mass in kg from body weight and body fat percentage.
+func LeanMassFromBodyFat(weightKg, bodyFatPercent float64) float64 {
+	return weightKg * (1 - bodyFatPercent/100)
+}
+
+// FFMI returns the fat-free mass index (lean mass / height squared, in kg/m²) and the
+// version normalized to a height of 1.8 m, which makes tall and short people comparable.
+func FFMI(leanMassKg, heightCm float64) (ffmi, normalized float64) {
+	heightM := heightCm / 100
+	ffmi = leanMassKg / (heightM * heightM)
+	return ffmi, ffmi + 6.1*(1.8-heightM)
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+)
+
+// passwordBreached reports whether the password is in the breached-password list at
+// BREACHED_PASSWORDS_FILE. Without that setting the check is skipped.
+//
+// The file has one uppercase SHA-1 hex digest per line, optionally followed by
+// ":<count>", sorted by digest. That is the "ordered by hash" download of the Have I
+// Been Pwned Pwned Passwords list, so it can be used as is. Lookups binary-search the
+// file on disk; nothing is loaded into memory and no network calls are made.
+func passwordBreached(password string) (bool, error) {
+	path := os.Getenv("BREACHED_PASSWORDS_FILE")
+	if path == "" {
+		return false, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	sum := sha1.Sum([]byte(password))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	// Find the first line starting at or after offset lo whose digest is >= the target
+	lo, hi := int64(0), info.Size()
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		line, err := lineAt(f, mid)
+		if err != nil {
+			return false, err
+		}
+		if line == "" || lineDigest(line) >= digest {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	line, err := lineAt(f, lo)
+	if err != nil {
+		return false, err
+	}
+	return lineDigest(line) == digest, nil
+}
+
+// lineAt returns the first complete line that starts at or after offset,
+// or "" at the end of the file.
+func lineAt(f *os.File, offset int64) (string, error) {
+	start := offset
+	if offset > 0 {
+		// Step back one byte so a line starting exactly at offset is not skipped
+		start = offset - 1
+	}
+	r := bufio.NewReader(io.NewSectionReader(f, start, 1<<20))
+	if offset > 0 {
+		if _, err := r.ReadBytes('\n'); err != nil {
+			if err == io.EOF {
+				return "", nil
+			}
+			return "", err
+		}
+	}
+	line, err := r.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return string(bytes.TrimSpace(line)), nil
+}
+
+func lineDigest(line string) string {
+	digest, _, _ := strings.Cut(line, ":")
+	return strings.ToUpper(digest)
+}
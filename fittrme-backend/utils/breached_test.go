@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// writeBreachedFile writes the digests of passwords in the format of the Have I Been
+// Pwned download: sorted, with a count and CRLF line endings.
+func writeBreachedFile(t *testing.T, path string, passwords ...string) {
+	t.Helper()
+	var digests []string
+	for _, p := range passwords {
+		digests = append(digests, sha1Hex(p))
+	}
+	writeDigestFile(t, path, digests)
+}
+
+func writeDigestFile(t *testing.T, path string, digests []string) {
+	t.Helper()
+	sort.Strings(digests)
+	var b strings.Builder
+	for i, d := range digests {
+		b.WriteString(d + ":" + strings.Repeat("7", i%5+1) + "\r\n")
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// neighbour returns digest with its last hex digit moved by delta.
+func neighbour(digest string, delta int) string {
+	const hexDigits = "0123456789ABCDEF"
+	last := strings.IndexByte(hexDigits, digest[len(digest)-1])
+	return digest[:len(digest)-1] + string(hexDigits[(last+delta+16)%16])
+}
+
+func TestPasswordBreached(t *testing.T) {
+	var listed []string
+	for i := 0; i < 200; i++ {
+		listed = append(listed, "listed-"+strings.Repeat("x", i))
+	}
+	var digests []string
+	for _, p := range listed {
+		digests = append(digests, sha1Hex(p))
+	}
+	sort.Strings(digests)
+	byDigest := map[string]string{}
+	for _, p := range listed {
+		byDigest[sha1Hex(p)] = p
+	}
+	first, last := byDigest[digests[0]], byDigest[digests[len(digests)-1]]
+
+	dir := t.TempDir()
+	full := filepath.Join(dir, "full.txt")
+	writeDigestFile(t, full, digests)
+
+	// Lines that share all but the last digit with "target", on both sides of it
+	target := "not-listed"
+	neighbours := filepath.Join(dir, "neighbours.txt")
+	writeDigestFile(t, neighbours, append([]string{neighbour(sha1Hex(target), -1), neighbour(sha1Hex(target), 1)}, digests...))
+
+	single := filepath.Join(dir, "single.txt")
+	writeBreachedFile(t, single, first)
+	empty := filepath.Join(dir, "empty.txt")
+	writeDigestFile(t, empty, nil)
+	noCounts := filepath.Join(dir, "nocounts.txt")
+	if err := os.WriteFile(noCounts, []byte(strings.Join(digests, "\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		file     string
+		password string
+		want     bool
+		wantErr  bool
+	}{
+		{name: "no list configured", file: "", password: first},
+		{name: "first line", file: full, password: first, want: true},
+		{name: "last line", file: full, password: last, want: true},
+		{name: "middle line", file: full, password: byDigest[digests[100]], want: true},
+		{name: "not listed", file: full, password: target},
+		{name: "between neighbouring digests", file: neighbours, password: target},
+		{name: "listed between neighbours", file: neighbours, password: first, want: true},
+		{name: "only line", file: single, password: first, want: true},
+		{name: "not the only line", file: single, password: last},
+		{name: "empty list", file: empty, password: first},
+		{name: "no counts or final newline", file: noCounts, password: last, want: true},
+		{name: "missing file", file: filepath.Join(dir, "missing.txt"), password: first, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("BREACHED_PASSWORDS_FILE", tt.file)
+			got, err := passwordBreached(tt.password)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Fatalf("passwordBreached = %v, %v; want %v, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+
+	// Every line is found wherever the search happens to land
+	t.Setenv("BREACHED_PASSWORDS_FILE", full)
+	for _, p := range listed {
+		if got, err := passwordBreached(p); !got || err != nil {
+			t.Errorf("passwordBreached(%q) = %v, %v; want true", p, got, err)
+		}
+	}
+}
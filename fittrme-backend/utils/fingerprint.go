@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"os"
+)
+
+// fingerprintKey is the secret HashFingerprint is keyed with, set by LoadFingerprintKey.
+var fingerprintKey []byte
+
+// LoadFingerprintKey reads the login fingerprint key from LOGIN_FINGERPRINT_KEY (a
+// random string of at least 32 characters). Like the signing keys, it is required.
+func LoadFingerprintKey() error {
+	key := os.Getenv("LOGIN_FINGERPRINT_KEY")
+	if len(key) < 32 {
+		return errors.New("LOGIN_FINGERPRINT_KEY must be at least 32 characters")
+	}
+	fingerprintKey = []byte(key)
+	return nil
+}
+
+// HashFingerprint returns the value stored for a login fingerprint: its HMAC-SHA256
+// under the fingerprint key, Base64-encoded (URL-safe, no padding). Unlike opaque
+// tokens, fingerprints can be guessed (an IPv4 /24 is one of about 16 million), so
+// without a secret key the stored values could be reversed by brute force.
+func HashFingerprint(value string) string {
+	mac := hmac.New(sha256.New, fingerprintKey)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -1,9 +1,6 @@
 package utils
 
 import (
-	"crypto/rand"
-	"crypto/sha256"
-	"encoding/base64"
 	"os"
 	"strconv"
 	"time"
@@ -11,31 +8,45 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func GenerateAccessToken(userID int) (string, error) {
-	// Load the JWT secret from environment variables
-	secret := os.Getenv("JWT_ACCESS_SECRET")
-	if secret == "" {
-		secret = "default-access-secret"
-	}
-
-	// Read the access token lifetime (in minutes)
+// AccessTokenTTL returns the access token lifetime from ACCESS_TOKEN_TTL_MIN (default 15 minutes).
+func AccessTokenTTL() time.Duration {
 	ttlMin, _ := strconv.Atoi(os.Getenv("ACCESS_TOKEN_TTL_MIN"))
 	if ttlMin == 0 {
 		ttlMin = 15
 	}
+	return time.Minute * time.Duration(ttlMin)
+}
+
+// TokenIssuer returns the "iss" claim of our access tokens (JWT_ISSUER, default "fittrme").
+func TokenIssuer() string {
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "fittrme"
+}
+
+func GenerateAccessToken(userID int, sessionID string, roles []string) (string, error) {
+	// Every access token gets a unique ID (jti) so it can be revoked on its own
+	jti, err := randomID(16)
+	if err != nil {
+		return "", err
+	}
 
-	// Define token claims with user ID, issue time, and expiration
+	// Define token claims with issuer, user ID, roles, session ID, token ID, issue time, and expiration
+	now := time.Now()
 	claims := jwt.MapClaims{
+		"iss":    TokenIssuer(),
+		"sub":    strconv.Itoa(userID),
 		"userId": userID,
-		"exp":    time.Now().Add(time.Minute * time.Duration(ttlMin)).Unix(),
-		"iat":    time.Now().Unix(),
+		"roles":  roles,
+		"sid":    sessionID,
+		"jti":    jti,
+		"exp":    now.Add(AccessTokenTTL()).Unix(),
+		"iat":    now.Unix(),
 	}
 
-	// Create a new JWT using the HS256 signing algorithm
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	// Sign the token using the secret key
-	return token.SignedString([]byte(secret))
+	// Sign the token with the active asymmetric key (see LoadSigningKeys)
+	return signToken(claims)
 }
 
 func NewRefreshToken() (rawToken string, hash string, exp time.Time, err error) {
@@ -43,27 +54,15 @@ func NewRefreshToken() (rawToken string, hash string, exp time.Time, err error)
 	// The raw token is returned to the client, while the hash
 	// is stored securely in the database for later validation.
 
-	// Allocate a 32-byte slice to hold random bytes for the token.
-	b := make([]byte, 32)
-
-	// Fill the slice with cryptographically secure random data.
+	// Generate 32 cryptographically secure random bytes for the raw token
+	// and hash it so that only the hashed value is stored in the database
+	// (similar to how passwords are stored).
 	// If generation fails, return immediately with an error.
-	_, err = rand.Read(b)
+	rawToken, hash, err = NewOpaqueToken()
 	if err != nil {
 		return "", "", time.Time{}, err
 	}
 
-	// Encode the random bytes into a URL-safe Base64 string
-	// so it can be safely sent to the client.
-	rawToken = base64.RawURLEncoding.EncodeToString(b)
-
-	// Hash the raw token using SHA-256 so that only the hashed value
-	// is stored in the database (similar to how passwords are stored).
-	sum := sha256.Sum256([]byte(rawToken))
-
-	// Base64-encode the hash to make it easier to store and compare.
-	hash = base64.RawURLEncoding.EncodeToString(sum[:])
-
 	// Read the token lifetime (in days) from the environment variable.
 	days, _ := strconv.Atoi(os.Getenv("REFRESH_TOKEN_TTL_DAYS"))
 
@@ -0,0 +1,216 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Access tokens are signed with an asymmetric key so other services can verify them
+// from the public JWKS without being able to mint tokens.
+//
+//	JWT_SIGNING_KEY       PKCS#8 PEM private key (Ed25519 -> EdDSA, RSA >= 2048 bits -> RS256).
+//	                      Generate one with: openssl genpkey -algorithm ed25519
+//	JWT_VERIFICATION_KEYS optional PEM bundle of extra PUBLIC KEY blocks that are still accepted,
+//	                      e.g. the previous signing key during a rotation.
+//
+// Every key is identified by its RFC 7638 JWK thumbprint, sent as the "kid" token header.
+// To rotate: publish the new public key in JWT_VERIFICATION_KEYS on every instance, then
+// switch JWT_SIGNING_KEY to the new key and move the old public key into JWT_VERIFICATION_KEYS
+// until the last token it signed has expired.
+
+type verificationKey struct {
+	method jwt.SigningMethod
+	public crypto.PublicKey
+	jwk    map[string]string
+}
+
+type keySet struct {
+	signingKID    string
+	signingMethod jwt.SigningMethod
+	signer        crypto.Signer
+	verification  map[string]verificationKey
+}
+
+var (
+	keysMu sync.RWMutex
+	keys   *keySet
+)
+
+// LoadSigningKeys reads the signing and verification keys from the environment.
+// It fails when the signing key is missing or unusable, so the server never
+// starts up issuing tokens with weak or default key material.
+func LoadSigningKeys() error {
+	signingPEM := envPEM("JWT_SIGNING_KEY")
+	if signingPEM == "" {
+		return errors.New("JWT_SIGNING_KEY is not set")
+	}
+	block, _ := pem.Decode([]byte(signingPEM))
+	if block == nil {
+		return errors.New("JWT_SIGNING_KEY is not a PEM block")
+	}
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("JWT_SIGNING_KEY: %w", err)
+	}
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return errors.New("JWT_SIGNING_KEY: unsupported key type")
+	}
+
+	set := &keySet{signer: signer, verification: map[string]verificationKey{}}
+	set.signingKID, err = set.add(signer.Public())
+	if err != nil {
+		return fmt.Errorf("JWT_SIGNING_KEY: %w", err)
+	}
+	set.signingMethod = set.verification[set.signingKID].method
+
+	rest := []byte(envPEM("JWT_VERIFICATION_KEYS"))
+	for {
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("JWT_VERIFICATION_KEYS: %w", err)
+		}
+		if _, err := set.add(public); err != nil {
+			return fmt.Errorf("JWT_VERIFICATION_KEYS: %w", err)
+		}
+	}
+	if strings.TrimSpace(string(rest)) != "" {
+		return errors.New("JWT_VERIFICATION_KEYS contains data that is not a PEM block")
+	}
+
+	keysMu.Lock()
+	keys = set
+	keysMu.Unlock()
+	return nil
+}
+
+// envPEM reads a PEM value from the environment, accepting "\n" escapes
+// for hosts that only support single-line variables.
+func envPEM(key string) string {
+	return strings.ReplaceAll(os.Getenv(key), `\n`, "\n")
+}
+
+// add registers a public key for verification and returns its kid.
+func (s *keySet) add(public crypto.PublicKey) (string, error) {
+	var key verificationKey
+	switch pub := public.(type) {
+	case ed25519.PublicKey:
+		key = verificationKey{
+			method: jwt.SigningMethodEdDSA,
+			public: pub,
+			jwk: map[string]string{
+				"kty": "OKP",
+				"crv": "Ed25519",
+				"x":   base64.RawURLEncoding.EncodeToString(pub),
+			},
+		}
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < 2048 {
+			return "", errors.New("RSA keys must be at least 2048 bits")
+		}
+		key = verificationKey{
+			method: jwt.SigningMethodRS256,
+			public: pub,
+			jwk: map[string]string{
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			},
+		}
+	default:
+		return "", fmt.Errorf("unsupported key type %T (use Ed25519 or RSA)", public)
+	}
+
+	kid, err := jwkThumbprint(key.jwk)
+	if err != nil {
+		return "", err
+	}
+	s.verification[kid] = key
+	return kid, nil
+}
+
+// jwkThumbprint computes the RFC 7638 thumbprint: SHA-256 over the required JWK
+// members serialized with sorted keys and no whitespace (encoding/json sorts map keys).
+func jwkThumbprint(jwk map[string]string) (string, error) {
+	b, err := json.Marshal(jwk)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func currentKeys() (*keySet, error) {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+	if keys == nil {
+		return nil, errors.New("signing keys are not loaded")
+	}
+	return keys, nil
+}
+
+// signToken signs claims with the active signing key and sets the kid header.
+func signToken(claims jwt.Claims) (string, error) {
+	set, err := currentKeys()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(set.signingMethod, claims)
+	token.Header["kid"] = set.signingKID
+	return token.SignedString(set.signer)
+}
+
+// VerificationKey is a jwt.Keyfunc that picks the public key named by the token's kid
+// and checks the token was signed with that key's algorithm.
+func VerificationKey(token *jwt.Token) (interface{}, error) {
+	set, err := currentKeys()
+	if err != nil {
+		return nil, err
+	}
+	kid, _ := token.Header["kid"].(string)
+	key, ok := set.verification[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.public, nil
+}
+
+// JWKS returns every verification key as a JSON Web Key Set.
+func JWKS() (map[string]interface{}, error) {
+	set, err := currentKeys()
+	if err != nil {
+		return nil, err
+	}
+	jwks := make([]map[string]string, 0, len(set.verification))
+	for kid, key := range set.verification {
+		jwk := map[string]string{"kid": kid, "use": "sig", "alg": key.method.Alg()}
+		for k, v := range key.jwk {
+			jwk[k] = v
+		}
+		jwks = append(jwks, jwk)
+	}
+	sort.Slice(jwks, func(i, j int) bool { return jwks[i]["kid"] < jwks[j]["kid"] })
+	return map[string]interface{}{"keys": jwks}, nil
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashes are stored as self-describing strings, so several schemes can coexist:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>   (PHC string format, new hashes)
+//	$2a$10$...                                      (bcrypt, accounts created before Argon2id)
+//
+// VerifyPassword reports when a hash uses an old scheme or old parameters, so the
+// caller can replace it with HashPassword after a successful login.
+//
+//	ARGON2_MEMORY_KB  memory in KiB (default 65536 = 64 MiB)
+//	ARGON2_TIME       number of passes (default 3)
+//	ARGON2_THREADS    degree of parallelism (default 2)
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+var errUnknownHashFormat = errors.New("unknown password hash format")
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+// currentArgon2Params reads the tuning parameters for new hashes from the environment.
+func currentArgon2Params() argon2Params {
+	p := argon2Params{memory: 64 * 1024, time: 3, threads: 2}
+	if v, _ := strconv.Atoi(os.Getenv("ARGON2_MEMORY_KB")); v >= 8*1024 {
+		p.memory = uint32(v)
+	}
+	if v, _ := strconv.Atoi(os.Getenv("ARGON2_TIME")); v > 0 {
+		p.time = uint32(v)
+	}
+	if v, _ := strconv.Atoi(os.Getenv("ARGON2_THREADS")); v > 0 && v < 256 {
+		p.threads = uint8(v)
+	}
+	return p
+}
+
+// HashPassword hashes a password with Argon2id using the current parameters.
+func HashPassword(password string) (string, error) {
+	p := currentArgon2Params()
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.memory, p.time, p.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// VerifyPassword checks a password against a stored hash. needsRehash is true when the
+// password matched but the hash should be upgraded to the current scheme and parameters.
+// An empty hash (an account without a password) never matches.
+func VerifyPassword(hash, password string) (ok bool, needsRehash bool, err error) {
+	switch {
+	case hash == "":
+		return false, false, nil
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return verifyArgon2id(hash, password)
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		return err == nil, err == nil, err
+	}
+	return false, false, errUnknownHashFormat
+}
+
+func verifyArgon2id(hash, password string) (bool, bool, error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, false, errUnknownHashFormat
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return false, false, errUnknownHashFormat
+	}
+	var p argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return false, false, errUnknownHashFormat
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, errUnknownHashFormat
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(want) == 0 {
+		return false, false, errUnknownHashFormat
+	}
+	if version != argon2.Version {
+		return false, false, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	got := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, uint32(len(want)))
+	if subtle.ConstantTimeCompare(got, want) != 1 {
+		return false, false, nil
+	}
+	return true, p != currentArgon2Params() || len(want) != argon2KeyLength, nil
+}
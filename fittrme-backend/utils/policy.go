@@ -0,0 +1,253 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Password policy, applied wherever a password is chosen (signup, change, reset).
+//
+//	PASSWORD_MIN_LENGTH     minimum length in characters (default 8)
+//	PASSWORD_MAX_LENGTH     maximum length in characters (default 128, at most MaxPasswordLength;
+//	                        hashing and strength estimation cost grow with it)
+//	PASSWORD_MIN_STRENGTH   minimum strength score from 0 to 4 (default 2), see PasswordStrength
+//	BREACHED_PASSWORDS_FILE optional breached-password list, see passwordBreached
+
+// MaxPasswordLength caps PASSWORD_MAX_LENGTH. The request bindings reject longer
+// passwords outright (binding:"max=256"), since PasswordStrength grows roughly with
+// the cube of the length.
+const MaxPasswordLength = 256
+
+type passwordPolicy struct {
+	minLength   int
+	maxLength   int
+	minStrength int
+}
+
+func currentPasswordPolicy() passwordPolicy {
+	p := passwordPolicy{minLength: 8, maxLength: 128, minStrength: 2}
+	if v, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH")); err == nil && v > 0 {
+		p.minLength = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("PASSWORD_MAX_LENGTH")); err == nil && v >= p.minLength {
+		p.maxLength = min(v, MaxPasswordLength)
+	}
+	if v, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_STRENGTH")); err == nil && v >= 0 && v <= 4 {
+		p.minStrength = v
+	}
+	return p
+}
+
+// CheckPassword returns every reason the password is not acceptable, or nil if it is.
+// username and email are the account's own, which the password must not contain.
+func CheckPassword(password, username, email string) []string {
+	p := currentPasswordPolicy()
+	var reasons []string
+
+	length := utf8.RuneCountInString(password)
+	if length < p.minLength {
+		reasons = append(reasons, fmt.Sprintf("Must be at least %d characters long", p.minLength))
+	}
+	if length > p.maxLength {
+		// Too long to estimate cheaply; the length alone is reason enough
+		return []string{fmt.Sprintf("Must be at most %d characters long", p.maxLength)}
+	}
+
+	lower := strings.ToLower(password)
+	localPart, _, _ := strings.Cut(strings.ToLower(email), "@")
+	for _, personal := range []string{strings.ToLower(username), localPart} {
+		if len(personal) >= 3 && strings.Contains(lower, personal) {
+			reasons = append(reasons, "Must not contain your username or email address")
+			break
+		}
+	}
+
+	if PasswordStrength(password, username, localPart) < p.minStrength {
+		reasons = append(reasons, "Too easy to guess; add more words or avoid common patterns")
+	}
+
+	breached, err := passwordBreached(password)
+	if err != nil {
+		// The list is a safety net; a missing or broken file must not block every signup
+		log.Println("Breached password check failed:", err)
+	} else if breached {
+		reasons = append(reasons, "This password has appeared in a data breach; choose a different one")
+	}
+
+	return reasons
+}
+
+// PasswordStrength estimates how hard a password is to guess, as a score from 0
+// (trivial) to 4 (very strong), in the spirit of zxcvbn: the password is split into
+// the cheapest sequence of known patterns (common passwords and words, keyboard runs,
+// sequences, repeats, years) and brute-forced characters, and the estimated number of
+// guesses decides the score. userInputs are treated as the most common words of all.
+func PasswordStrength(password string, userInputs ...string) int {
+	log10Guesses := estimateGuesses(password, userInputs)
+	switch {
+	case log10Guesses < 3:
+		return 0
+	case log10Guesses < 6:
+		return 1
+	case log10Guesses < 8:
+		return 2
+	case log10Guesses < 10:
+		return 3
+	}
+	return 4
+}
+
+// commonPasswords are ranked by popularity; a match costs its rank in guesses.
+var commonPasswords = strings.Fields(`
+	password 123456 qwerty letmein welcome monkey dragon football baseball iloveyou
+	admin login master sunshine princess shadow superman batman trustno1 starwars
+	hello freedom whatever charlie michael jennifer hunter ashley fitness fittrme
+	summer winter spring autumn secret access flower cookie pepper ginger soccer
+	hockey killer jordan harley ranger buster thomas tigger robert daniel andrew
+	joshua matthew maggie orange banana computer internet samsung google apple
+	love lovely angel family friend forever money change diamond purple yellow
+	silver golden chocolate cheese pizza coffee health muscle workout running
+	strong power gym weight diet skinny fat body cardio yoga abc test guest user
+	`)
+
+var commonPasswordRank = func() map[string]int {
+	ranks := make(map[string]int, len(commonPasswords))
+	for i, w := range commonPasswords {
+		ranks[w] = i + 1
+	}
+	return ranks
+}()
+
+var keyboardRows = []string{"qwertyuiop", "asdfghjkl", "zxcvbnm", "1234567890", "qazwsxedcrfvtgbyhnujmikolp"}
+
+// unleet maps common character substitutions back to letters.
+var unleet = strings.NewReplacer("@", "a", "4", "a", "3", "e", "1", "i", "!", "i", "0", "o", "$", "s", "5", "s", "7", "t", "+", "t")
+
+// estimateGuesses returns log10 of the estimated number of guesses for password.
+func estimateGuesses(password string, userInputs []string) float64 {
+	runes := []rune(password)
+	n := len(runes)
+	if n == 0 {
+		return 0
+	}
+	lower := []rune(strings.ToLower(password))
+	plain := []rune(unleet.Replace(strings.ToLower(password)))
+	if len(plain) != n {
+		plain = lower
+	}
+
+	personal := map[string]bool{}
+	for _, in := range userInputs {
+		if in = strings.ToLower(in); len(in) >= 3 {
+			personal[in] = true
+		}
+	}
+
+	// best[i] is the log10 guesses for the cheapest cover of runes[:i]; segments[i] counts its parts
+	best := make([]float64, n+1)
+	segments := make([]int, n+1)
+	for i := 1; i <= n; i++ {
+		best[i] = math.Inf(1)
+		for j := 0; j < i; j++ {
+			cost := best[j] + segmentGuesses(runes[j:i], lower[j:i], plain[j:i], personal)
+			if cost < best[i] {
+				best[i] = cost
+				segments[i] = segments[j] + 1
+			}
+		}
+	}
+
+	// Combining k patterns in some order takes roughly k! more guesses
+	total := best[n]
+	for k := 2; k <= segments[n]; k++ {
+		total += math.Log10(float64(k))
+	}
+	return total
+}
+
+// segmentGuesses returns log10 of the guesses needed for one part of the password,
+// the cheapest of the patterns it matches or brute force.
+func segmentGuesses(original, lower, plain []rune, personal map[string]bool) float64 {
+	n := len(original)
+	// Brute force: zxcvbn assumes a cardinality of 10 per character
+	guesses := float64(n)
+	if n < 2 {
+		return guesses
+	}
+
+	// Capitalization and leet substitutions each roughly double a word's guesses
+	variation := 0.0
+	if string(lower) != string(original) {
+		variation += math.Log10(2)
+	}
+	if string(plain) != string(lower) {
+		variation += math.Log10(2)
+	}
+
+	word := string(plain)
+	if personal[word] || personal[string(lower)] {
+		guesses = math.Min(guesses, variation)
+	}
+	if rank, ok := commonPasswordRank[word]; ok {
+		guesses = math.Min(guesses, math.Log10(float64(rank))+variation)
+	} else if rank, ok := commonPasswordRank[string(lower)]; ok {
+		guesses = math.Min(guesses, math.Log10(float64(rank))+variation)
+	}
+
+	s := string(lower)
+	if n >= 3 && isRepeat(lower) {
+		guesses = math.Min(guesses, math.Log10(95*float64(n)))
+	}
+	if n >= 3 && isSequence(lower) {
+		guesses = math.Min(guesses, math.Log10(26*2*float64(n)))
+	}
+	if n >= 4 {
+		for _, row := range keyboardRows {
+			if strings.Contains(row, s) || strings.Contains(reverse(row), s) {
+				guesses = math.Min(guesses, math.Log10(float64(len(row)*2*n)))
+			}
+		}
+	}
+	if n == 4 {
+		if year, err := strconv.Atoi(s); err == nil && year >= 1900 && year <= 2099 {
+			guesses = math.Min(guesses, math.Log10(200))
+		}
+	}
+	return guesses
+}
+
+func isRepeat(r []rune) bool {
+	for _, c := range r[1:] {
+		if c != r[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// isSequence reports whether r steps by a constant +1 or -1, like "abc" or "987".
+func isSequence(r []rune) bool {
+	step := r[1] - r[0]
+	if step != 1 && step != -1 {
+		return false
+	}
+	for i := 2; i < len(r); i++ {
+		if r[i]-r[i-1] != step {
+			return false
+		}
+	}
+	return true
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
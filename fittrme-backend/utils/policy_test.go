@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPasswordStrength(t *testing.T) {
+	tests := []struct {
+		password string
+		want     int
+	}{
+		{"", 0},
+		{"password", 0},
+		{"P@ssw0rd", 0},    // common password with capitalization and leet
+		{"qwertyuiop", 0},  // keyboard row
+		{"aaaaaaaa", 0},    // repeat
+		{"abcdefgh", 0},    // sequence
+		{"1999", 0},        // year
+		{"samuel", 0},      // the user's own input
+		{"Samuel2024!", 1}, // user input, year and one brute-forced character
+		{"Summer1999", 1},  // common password and year
+		{"fittrme123", 1},  // common password and sequence
+		{"kx7qzrw", 2},     // 7 brute-forced characters
+		{"kx7qzrwbm", 3},   // 9 brute-forced characters
+		{"xK9#mQ2$vL", 4},  // 10 brute-forced characters
+		{"tiger lamp orbit velvet", 4},
+	}
+	for _, tt := range tests {
+		if got := PasswordStrength(tt.password, "samuel", "sam"); got != tt.want {
+			t.Errorf("PasswordStrength(%q) = %d, want %d", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	breached := filepath.Join(t.TempDir(), "breached.txt")
+	writeBreachedFile(t, breached, "kx7qzrwbm#2")
+
+	tests := []struct {
+		name     string
+		password string
+		env      map[string]string
+		want     []string
+	}{
+		{name: "acceptable", password: "kx7qzrwbm#1"},
+		{
+			name: "too short and weak", password: "kx7q",
+			want: []string{"Must be at least 8 characters long", "Too easy to guess; add more words or avoid common patterns"},
+		},
+		{
+			// Nothing else is checked once the password is too long
+			name: "too long", password: strings.Repeat("a", 129),
+			want: []string{"Must be at most 128 characters long"},
+		},
+		{
+			name: "maximum above the cap", password: strings.Repeat("kx7qzrwbm#", 26),
+			env:  map[string]string{"PASSWORD_MAX_LENGTH": "1000"},
+			want: []string{"Must be at most 256 characters long"},
+		},
+		{
+			name: "contains the username", password: "Samuel-kx7qzrwbm",
+			want: []string{"Must not contain your username or email address"},
+		},
+		{
+			name: "contains the email address", password: "kx7qzrwbm-sam.l",
+			want: []string{"Must not contain your username or email address"},
+		},
+		{
+			name: "lower minimum strength", password: "Summer1999",
+			env: map[string]string{"PASSWORD_MIN_STRENGTH": "1"},
+		},
+		{
+			name: "breached", password: "kx7qzrwbm#2",
+			env:  map[string]string{"BREACHED_PASSWORDS_FILE": breached},
+			want: []string{"This password has appeared in a data breach; choose a different one"},
+		},
+		{
+			// A broken list is logged, not held against the password
+			name: "missing breached list", password: "kx7qzrwbm#2",
+			env: map[string]string{"BREACHED_PASSWORDS_FILE": filepath.Join(t.TempDir(), "missing.txt")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"PASSWORD_MIN_LENGTH", "PASSWORD_MAX_LENGTH", "PASSWORD_MIN_STRENGTH", "BREACHED_PASSWORDS_FILE"} {
+				t.Setenv(key, tt.env[key])
+			}
+			got := CheckPassword(tt.password, "samuel", "sam.l@example.com")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("CheckPassword = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
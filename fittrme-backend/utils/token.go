@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"strings"
+)
+
+// NewOpaqueToken generates a random single-purpose token (refresh, password reset, ...).
+// The raw value is handed to the client; only the hash is stored in the database.
+func NewOpaqueToken() (rawToken string, hash string, err error) {
+	rawToken, err = randomID(32)
+	if err != nil {
+		return "", "", err
+	}
+	return rawToken, HashToken(rawToken), nil
+}
+
+// HashToken returns the value stored in the database for a raw opaque token:
+// the SHA-256 digest of the token, Base64-encoded (URL-safe, no padding).
+func HashToken(rawToken string) string {
+	sum := sha256.Sum256([]byte(rawToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// NewTokenFamilyID returns a random identifier for a refresh token family.
+// Every refresh token rotated out of the same login shares this ID.
+func NewTokenFamilyID() (string, error) {
+	return randomID(16)
+}
+
+// randomID returns n cryptographically random bytes, Base64-encoded (URL-safe, no padding).
+func randomID(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// NewRecoveryCode returns a random one-time MFA recovery code such as "k3x9q-7hd2m".
+func NewRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// NormalizeRecoveryCode lower-cases a recovery code and strips spaces and dashes,
+// so codes typed by hand hash to the same value as the issued ones.
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer(" ", "", "-", "").Replace(code)
+}
@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors, "12345678901234567890", in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B (SHA-1). The RFC lists 8-digit codes; 6-digit codes are their last six digits.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		code, err := TOTPCode(rfc6238Secret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil || code != tt.want {
+			t.Errorf("TOTPCode at %d = %q, %v; want %q", tt.unix, code, err, tt.want)
+		}
+	}
+
+	// Secrets are accepted in lower case, as some apps display them
+	if code, err := TOTPCode(strings.ToLower(rfc6238Secret), TOTPStep(time.Unix(59, 0))); err != nil || code != "287082" {
+		t.Errorf("lower-case secret: %q, %v", code, err)
+	}
+	if _, err := TOTPCode("not base32!", 1); err == nil {
+		t.Error("invalid secret accepted")
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := TOTPStep(now)
+	codeAt := func(step int64) string {
+		code, err := TOTPCode(rfc6238Secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{name: "current step", code: codeAt(current), wantStep: current, wantOK: true},
+		{name: "previous step", code: codeAt(current - 1), wantStep: current - 1, wantOK: true},
+		{name: "next step", code: codeAt(current + 1), wantStep: current + 1, wantOK: true},
+		{name: "two steps ago", code: codeAt(current - 2)},
+		{name: "two steps ahead", code: codeAt(current + 2)},
+		{name: "typed with spaces", code: " 050 471 ", wantStep: current, wantOK: true},
+		{name: "wrong code", code: "123456"},
+		{name: "too short", code: "05047"},
+		{name: "8-digit RFC code", code: "14050471"},
+		{name: "empty", code: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateTOTP(rfc6238Secret, tt.code, now)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Fatalf("ValidateTOTP(%q) = %d, %v; want %d, %v", tt.code, step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestNewTOTPSecret(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 160 bits in base32 without padding
+	if len(secret) != 32 {
+		t.Fatalf("secret %q has %d characters, want 32", secret, len(secret))
+	}
+	if _, err := TOTPCode(secret, 1); err != nil {
+		t.Fatalf("new secret unusable: %v", err)
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	got := TOTPURI("FittrMe", "sam@example.com", rfc6238Secret)
+	want := "otpauth://totp/FittrMe:sam@example.com?algorithm=SHA1&digits=6&issuer=FittrMe&period=30&secret=" + rfc6238Secret
+	if got != want {
+		t.Fatalf("TOTPURI = %s, want %s", got, want)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	format := regexp.MustCompile(`^[a-z2-7]{5}-[a-z2-7]{5}$`)
+	seen := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		code, err := NewRecoveryCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !format.MatchString(code) {
+			t.Fatalf("recovery code %q does not look like xxxxx-xxxxx", code)
+		}
+		if seen[code] {
+			t.Fatalf("recovery code %q issued twice", code)
+		}
+		seen[code] = true
+
+		// Typed by hand, the code still hashes like the issued one
+		typed := strings.ToUpper(strings.ReplaceAll(code, "-", " "))
+		if NormalizeRecoveryCode(typed) != NormalizeRecoveryCode(code) {
+			t.Fatalf("%q normalizes to %q, want %q", typed, NormalizeRecoveryCode(typed), NormalizeRecoveryCode(code))
+		}
+	}
+}
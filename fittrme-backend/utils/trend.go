@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"math"
+	"time"
+)
+
+// Weight trend analysis: an exponentially weighted moving average (EWMA) over daily
+// weigh-ins, and a linear fit for the rate of change and the goal-date projection.
+
+// DailyWeight is the mean of all weigh-ins on one calendar day. Day is midnight UTC of
+// that date, so whole days can be counted by subtracting.
+type DailyWeight struct {
+	Day    time.Time
+	Weight float64
+}
+
+// TrendPoint is one day of the trend line. Weight is nil on days without a weigh-in.
+type TrendPoint struct {
+	Date   string   `json:"date"` // YYYY-MM-DD
+	Weight *float64 `json:"weight"`
+	Trend  float64  `json:"trend"`
+}
+
+func daysBetween(a, b time.Time) float64 {
+	return math.Round(b.Sub(a).Hours() / 24)
+}
+
+// EWMATrend smooths daily weights (sorted by day, at least one) with an EWMA whose
+// window is in days (alpha = 2 / (window + 1)). It returns a point for every day from
+// the first weigh-in to the last.
+//
+// A day without a weigh-in carries the trend over unchanged rather than inventing a
+// value. The next weigh-in then counts as much as the skipped days would have together:
+// after a gap of g days it moves the trend by 1 - (1 - alpha)^g of the difference.
+func EWMATrend(days []DailyWeight, window int) []TrendPoint {
+	if len(days) == 0 {
+		return nil
+	}
+	alpha := 2 / (float64(window) + 1)
+	total := int(daysBetween(days[0].Day, days[len(days)-1].Day)) + 1
+	points := make([]TrendPoint, 0, total)
+
+	trend := days[0].Weight
+	next := 0
+	lastMeasured := days[0].Day
+	for i := 0; i < total; i++ {
+		day := days[0].Day.AddDate(0, 0, i)
+		point := TrendPoint{Date: day.Format(time.DateOnly)}
+		if next < len(days) && daysBetween(days[next].Day, day) == 0 {
+			weight := days[next].Weight
+			gap := daysBetween(lastMeasured, day)
+			trend += (1 - math.Pow(1-alpha, gap)) * (weight - trend)
+			lastMeasured = day
+			point.Weight = &weight
+			next++
+		}
+		point.Trend = trend
+		points = append(points, point)
+	}
+	return points
+}
+
+// LinearRate fits weight = a + b * day by least squares over the daily weights and
+// returns the slope b (change per day) with its standard error. ok is false with fewer
+// than three days, since the error cannot be estimated from two.
+func LinearRate(days []DailyWeight) (slope, stdErr float64, ok bool) {
+	n := float64(len(days))
+	if len(days) < 3 {
+		return 0, 0, false
+	}
+	var meanX, meanY float64
+	for _, d := range days {
+		meanX += daysBetween(days[0].Day, d.Day)
+		meanY += d.Weight
+	}
+	meanX /= n
+	meanY /= n
+
+	var sxx, sxy float64
+	for _, d := range days {
+		dx := daysBetween(days[0].Day, d.Day) - meanX
+		sxx += dx * dx
+		sxy += dx * (d.Weight - meanY)
+	}
+	if sxx == 0 {
+		return 0, 0, false
+	}
+	slope = sxy / sxx
+
+	var sse float64
+	for _, d := range days {
+		residual := d.Weight - (meanY + slope*(daysBetween(days[0].Day, d.Day)-meanX))
+		sse += residual * residual
+	}
+	stdErr = math.Sqrt(sse / (n - 2) / sxx)
+	return slope, stdErr, true
+}
+
+// DaysToTarget returns how many days a weight takes to go from current to target at a
+// rate per day, or false if that rate never gets there.
+func DaysToTarget(current, target, ratePerDay float64) (float64, bool) {
+	remaining := target - current
+	if ratePerDay == 0 || math.Signbit(remaining) != math.Signbit(ratePerDay) {
+		return 0, false
+	}
+	return remaining / ratePerDay, true
+}
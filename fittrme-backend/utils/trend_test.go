@@ -0,0 +1,184 @@
+package utils
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// daily builds daily weights from day offsets after 2025-01-01 and weights.
+func daily(offsets []int, weights []float64) []DailyWeight {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	days := make([]DailyWeight, len(offsets))
+	for i, offset := range offsets {
+		days[i] = DailyWeight{Day: start.AddDate(0, 0, offset), Weight: weights[i]}
+	}
+	return days
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestEWMATrend(t *testing.T) {
+	tests := []struct {
+		name      string
+		offsets   []int
+		weights   []float64
+		window    int
+		wantDates []string
+		wantTrend []float64
+		measured  []bool
+	}{
+		{
+			name:      "no weigh-ins",
+			window:    9,
+			wantDates: []string{},
+		},
+		{
+			name:      "single weigh-in",
+			offsets:   []int{0},
+			weights:   []float64{80},
+			window:    9,
+			wantDates: []string{"2025-01-01"},
+			wantTrend: []float64{80},
+			measured:  []bool{true},
+		},
+		{
+			// alpha = 0.2
+			name:      "consecutive days",
+			offsets:   []int{0, 1, 2},
+			weights:   []float64{80, 81, 79},
+			window:    9,
+			wantDates: []string{"2025-01-01", "2025-01-02", "2025-01-03"},
+			wantTrend: []float64{80, 80.2, 79.96},
+			measured:  []bool{true, true, true},
+		},
+		{
+			// The gap carries 80.2 over; the next weigh-in moves it by 1 - 0.8^3 = 0.488
+			// of the difference.
+			name:      "gap",
+			offsets:   []int{0, 1, 4},
+			weights:   []float64{80, 81, 78},
+			window:    9,
+			wantDates: []string{"2025-01-01", "2025-01-02", "2025-01-03", "2025-01-04", "2025-01-05"},
+			wantTrend: []float64{80, 80.2, 80.2, 80.2, 80.2 + 0.488*(78-80.2)},
+			measured:  []bool{true, true, false, false, true},
+		},
+		{
+			name:      "flat",
+			offsets:   []int{0, 3, 5},
+			weights:   []float64{80, 80, 80},
+			window:    1,
+			wantDates: []string{"2025-01-01", "2025-01-02", "2025-01-03", "2025-01-04", "2025-01-05", "2025-01-06"},
+			wantTrend: []float64{80, 80, 80, 80, 80, 80},
+			measured:  []bool{true, false, false, true, false, true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points := EWMATrend(daily(tt.offsets, tt.weights), tt.window)
+			if len(points) != len(tt.wantDates) {
+				t.Fatalf("got %d points, want %d", len(points), len(tt.wantDates))
+			}
+			next := 0
+			for i, p := range points {
+				if p.Date != tt.wantDates[i] || !near(p.Trend, tt.wantTrend[i]) {
+					t.Errorf("point %d = %s %v, want %s %v", i, p.Date, p.Trend, tt.wantDates[i], tt.wantTrend[i])
+				}
+				if (p.Weight != nil) != tt.measured[i] {
+					t.Errorf("point %d has weight %v, want measured = %v", i, p.Weight, tt.measured[i])
+				} else if p.Weight != nil {
+					if *p.Weight != tt.weights[next] {
+						t.Errorf("point %d weight = %v, want %v", i, *p.Weight, tt.weights[next])
+					}
+					next++
+				}
+			}
+		})
+	}
+}
+
+// A weigh-in after a gap counts as much as the same weight on every skipped day.
+func TestEWMATrendGapMatchesDailyWeighIns(t *testing.T) {
+	gap := EWMATrend(daily([]int{0, 5}, []float64{80, 75}), 7)
+	everyDay := EWMATrend(daily([]int{0, 1, 2, 3, 4, 5}, []float64{80, 75, 75, 75, 75, 75}), 7)
+	if last, want := gap[len(gap)-1].Trend, everyDay[len(everyDay)-1].Trend; !near(last, want) {
+		t.Fatalf("trend after gap = %v, want %v", last, want)
+	}
+}
+
+func TestLinearRate(t *testing.T) {
+	tests := []struct {
+		name       string
+		offsets    []int
+		weights    []float64
+		wantSlope  float64
+		wantStdErr float64
+		wantOK     bool
+	}{
+		{name: "no weigh-ins"},
+		{name: "single weigh-in", offsets: []int{0}, weights: []float64{80}},
+		{name: "two weigh-ins", offsets: []int{0, 7}, weights: []float64{80, 79}},
+		{name: "all on one day", offsets: []int{0, 0, 0}, weights: []float64{80, 81, 79}},
+		{
+			name:    "exact line",
+			offsets: []int{0, 1, 2, 3}, weights: []float64{80, 79.9, 79.8, 79.7},
+			wantSlope: -0.1, wantOK: true,
+		},
+		{
+			name:    "exact line with gaps",
+			offsets: []int{0, 2, 6}, weights: []float64{80, 79.8, 79.4},
+			wantSlope: -0.1, wantOK: true,
+		},
+		{
+			name:    "flat",
+			offsets: []int{0, 1, 5}, weights: []float64{80, 80, 80},
+			wantSlope: 0, wantOK: true,
+		},
+		{
+			// Residuals 0.05, -0.2, 0.25, -0.1: SSE 0.115 over 2 degrees of freedom, Sxx 5
+			name:    "noisy",
+			offsets: []int{0, 1, 2, 3}, weights: []float64{80, 79.5, 79.7, 79.1},
+			wantSlope: -0.25, wantStdErr: math.Sqrt(0.115 / 2 / 5), wantOK: true,
+		},
+		{
+			name:    "noisy without trend",
+			offsets: []int{0, 1, 2}, weights: []float64{80, 81, 80},
+			wantSlope: 0, wantStdErr: math.Sqrt(2.0 / 3 / 2), wantOK: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slope, stdErr, ok := LinearRate(daily(tt.offsets, tt.weights))
+			if ok != tt.wantOK || !near(slope, tt.wantSlope) || !near(stdErr, tt.wantStdErr) {
+				t.Fatalf("LinearRate = %v ± %v, %v; want %v ± %v, %v", slope, stdErr, ok, tt.wantSlope, tt.wantStdErr, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestDaysToTarget(t *testing.T) {
+	tests := []struct {
+		name            string
+		current, target float64
+		rate            float64
+		wantDays        float64
+		wantOK          bool
+	}{
+		{name: "losing", current: 80, target: 75, rate: -0.5, wantDays: 10, wantOK: true},
+		{name: "gaining", current: 60, target: 65, rate: 0.25, wantDays: 20, wantOK: true},
+		{name: "flat", current: 80, target: 75, rate: 0},
+		{name: "gaining instead of losing", current: 80, target: 75, rate: 0.1},
+		{name: "losing instead of gaining", current: 60, target: 65, rate: -0.1},
+		{name: "at target", current: 75, target: 75, rate: 0.1, wantDays: 0, wantOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			days, ok := DaysToTarget(tt.current, tt.target, tt.rate)
+			if ok != tt.wantOK || !near(days, tt.wantDays) {
+				t.Fatalf("DaysToTarget = %v, %v; want %v, %v", days, ok, tt.wantDays, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package utils
+
+import "math"
+
+// Conversions between metric and imperial units. Body values are stored in kg and cm;
+// imperial values are converted at the API boundary.
+
+// Exact conversion factors (international yard and pound agreement, 1959).
+const (
+	KgPerLb   = 0.45359237
+	CmPerInch = 2.54
+)
+
+func LbToKg(lb float64) float64 {
+	return lb * KgPerLb
+}
+
+func KgToLb(kg float64) float64 {
+	return kg / KgPerLb
+}
+
+func InchToCm(in float64) float64 {
+	return in * CmPerInch
+}
+
+func CmToInch(cm float64) float64 {
+	return cm / CmPerInch
+}
+
+// RoundTo rounds v half away from zero to the given number of decimals.
+func RoundTo(v float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(v*scale) / scale
+}
@@ -0,0 +1,234 @@
+// Package webauthn implements the relying-party checks of the WebAuthn
+// registration and authentication ceremonies (W3C Web Authentication Level 2,
+// sections 7.1 and 7.2) for passkeys.
+//
+// Only "none" attestation is requested, so attestation statements are not
+// verified; the credential is trusted because it is registered by a signed-in user.
+//
+//	WEBAUTHN_RP_ID     relying party ID, the app's domain (default "localhost")
+//	WEBAUTHN_RP_NAME   name shown by the authenticator (default "FittrMe")
+//	WEBAUTHN_ORIGINS   comma-separated allowed origins, e.g. "https://fittrme.app,android:apk-key-hash:..."
+//	                   (default "https://<rp id>")
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ErrCounterRegression means the authenticator's signature counter went backwards,
+// which suggests the credential was cloned.
+var ErrCounterRegression = errors.New("webauthn: signature counter did not increase")
+
+// Authenticator data flags.
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+// Config identifies the relying party.
+type Config struct {
+	RPID    string
+	RPName  string
+	Origins []string
+}
+
+// ConfigFromEnv reads the relying party configuration from the environment.
+func ConfigFromEnv() Config {
+	cfg := Config{RPID: os.Getenv("WEBAUTHN_RP_ID"), RPName: os.Getenv("WEBAUTHN_RP_NAME")}
+	if cfg.RPID == "" {
+		cfg.RPID = "localhost"
+	}
+	if cfg.RPName == "" {
+		cfg.RPName = "FittrMe"
+	}
+	for _, origin := range strings.Split(os.Getenv("WEBAUTHN_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			cfg.Origins = append(cfg.Origins, origin)
+		}
+	}
+	if len(cfg.Origins) == 0 {
+		cfg.Origins = []string{"https://" + cfg.RPID}
+	}
+	return cfg
+}
+
+// Credential is a newly registered public key credential.
+type Credential struct {
+	ID        []byte
+	PublicKey []byte // COSE_Key encoding, as stored
+	SignCount uint32
+	AAGUID    []byte
+}
+
+// NewChallenge returns a random challenge, Base64-encoded (URL-safe, no padding)
+// as it appears in clientDataJSON.
+func NewChallenge() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// DecodeBase64URL decodes the Base64URL values browsers produce, with or without padding.
+func DecodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// verifyClientData checks the ceremony type, challenge and origin of clientDataJSON.
+func (cfg Config) verifyClientData(clientDataJSON []byte, ceremony, challenge string) error {
+	var cd clientData
+	if err := json.Unmarshal(clientDataJSON, &cd); err != nil {
+		return fmt.Errorf("webauthn: invalid client data: %w", err)
+	}
+	if cd.Type != ceremony {
+		return fmt.Errorf("webauthn: unexpected client data type %q", cd.Type)
+	}
+	if subtle.ConstantTimeCompare([]byte(cd.Challenge), []byte(challenge)) != 1 {
+		return errors.New("webauthn: challenge mismatch")
+	}
+	if !slices.Contains(cfg.Origins, cd.Origin) || cd.CrossOrigin {
+		return fmt.Errorf("webauthn: origin %q is not allowed", cd.Origin)
+	}
+	return nil
+}
+
+type authenticatorData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+	rest      []byte // attested credential data and extensions, if present
+}
+
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, errors.New("webauthn: authenticator data too short")
+	}
+	return &authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+		rest:      data[37:],
+	}, nil
+}
+
+// verify checks the RP ID hash and that the user was present and verified.
+func (cfg Config) verifyAuthenticatorData(ad *authenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(cfg.RPID))
+	if !bytes.Equal(ad.rpIDHash, rpIDHash[:]) {
+		return errors.New("webauthn: RP ID mismatch")
+	}
+	if ad.flags&flagUserPresent == 0 {
+		return errors.New("webauthn: user not present")
+	}
+	// Passkeys replace the password, so the authenticator must have verified the user
+	// (PIN, biometrics) and not just checked for a tap
+	if ad.flags&flagUserVerified == 0 {
+		return errors.New("webauthn: user not verified")
+	}
+	return nil
+}
+
+// VerifyRegistration runs the registration ceremony checks on an authenticator's
+// attestation response and returns the new credential.
+func (cfg Config) VerifyRegistration(challenge string, clientDataJSON, attestationObject []byte) (*Credential, error) {
+	if err := cfg.verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	item, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("webauthn: invalid attestation object: %w", err)
+	}
+	attestation, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("webauthn: invalid attestation object")
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("webauthn: attestation object has no authData")
+	}
+
+	ad, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.verifyAuthenticatorData(ad); err != nil {
+		return nil, err
+	}
+	if ad.flags&flagAttestedData == 0 || len(ad.rest) < 18 {
+		return nil, errors.New("webauthn: no attested credential data")
+	}
+
+	// Attested credential data: AAGUID (16) | credential ID length (2) | credential ID | COSE key
+	aaguid := ad.rest[:16]
+	idLen := int(binary.BigEndian.Uint16(ad.rest[16:18]))
+	if idLen == 0 || idLen > 1023 || len(ad.rest) < 18+idLen {
+		return nil, errors.New("webauthn: invalid credential ID")
+	}
+	credentialID := ad.rest[18 : 18+idLen]
+	keyBytes := ad.rest[18+idLen:]
+	_, after, err := parseCOSEKey(keyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("webauthn: %w", err)
+	}
+
+	return &Credential{
+		ID:        bytes.Clone(credentialID),
+		PublicKey: bytes.Clone(keyBytes[:len(keyBytes)-len(after)]),
+		SignCount: ad.signCount,
+		AAGUID:    bytes.Clone(aaguid),
+	}, nil
+}
+
+// VerifyAssertion runs the authentication ceremony checks on an assertion made with
+// the stored credential and returns the authenticator's new signature counter.
+func (cfg Config) VerifyAssertion(challenge string, storedPublicKey []byte, storedSignCount uint32,
+	clientDataJSON, authenticatorDataBytes, signature []byte) (uint32, error) {
+	if err := cfg.verifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+
+	ad, err := parseAuthenticatorData(authenticatorDataBytes)
+	if err != nil {
+		return 0, err
+	}
+	if err := cfg.verifyAuthenticatorData(ad); err != nil {
+		return 0, err
+	}
+
+	key, _, err := parseCOSEKey(storedPublicKey)
+	if err != nil {
+		return 0, fmt.Errorf("webauthn: %w", err)
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(bytes.Clone(authenticatorDataBytes), clientDataHash[:]...)
+	if err := key.verify(signed, signature); err != nil {
+		return 0, fmt.Errorf("webauthn: %w", err)
+	}
+
+	// Authenticators that keep no counter always report 0 (most synced passkeys)
+	if (ad.signCount != 0 || storedSignCount != 0) && ad.signCount <= storedSignCount {
+		return 0, ErrCounterRegression
+	}
+	return ad.signCount, nil
+}
@@ -0,0 +1,349 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// A software authenticator producing the attestation objects and assertions a
+// platform authenticator would, with a small CBOR encoder for its output.
+
+type cborPair struct {
+	key, value interface{}
+}
+
+// cborMap keeps its keys in order, since authenticators use canonical ordering.
+type cborMap []cborPair
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	}
+	return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+}
+
+func encodeCBOR(v interface{}) []byte {
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+		return cborHead(0, uint64(v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case []interface{}:
+		out := cborHead(4, uint64(len(v)))
+		for _, item := range v {
+			out = append(out, encodeCBOR(item)...)
+		}
+		return out
+	case cborMap:
+		out := cborHead(5, uint64(len(v)))
+		for _, p := range v {
+			out = append(out, encodeCBOR(p.key)...)
+			out = append(out, encodeCBOR(p.value)...)
+		}
+		return out
+	}
+	panic("encodeCBOR: unsupported type")
+}
+
+type softAuthenticator struct {
+	credentialID []byte
+	coseKey      []byte
+	sign         func(message []byte) []byte
+	signCount    uint32
+}
+
+func newES256Authenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := priv.X.FillBytes(make([]byte, 32))
+	y := priv.Y.FillBytes(make([]byte, 32))
+	return &softAuthenticator{
+		credentialID: randomBytes(t, 16),
+		coseKey: encodeCBOR(cborMap{
+			{coseKeyType, coseKeyTypeEC2}, {coseAlg, AlgES256}, {coseCurve, coseCurveP256}, {coseX, x}, {coseY, y},
+		}),
+		sign: func(message []byte) []byte {
+			digest := sha256.Sum256(message)
+			sig, err := ecdsa.SignASN1(rand.Reader, priv, digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return sig
+		},
+	}
+}
+
+func newEdDSAAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{
+		credentialID: randomBytes(t, 32),
+		coseKey: encodeCBOR(cborMap{
+			{coseKeyType, coseKeyTypeOKP}, {coseAlg, AlgEdDSA}, {coseCurve, coseCurveEd25519}, {coseX, []byte(pub)},
+		}),
+		sign: func(message []byte) []byte { return ed25519.Sign(priv, message) },
+	}
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func clientDataJSON(ceremony, challenge, origin string) []byte {
+	b, _ := json.Marshal(clientData{Type: ceremony, Challenge: challenge, Origin: origin})
+	return b
+}
+
+func (a *softAuthenticator) authData(rpID string, flags byte, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	if attested {
+		data = append(data, make([]byte, 16)...) // AAGUID
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+		data = append(data, a.credentialID...)
+		data = append(data, a.coseKey...)
+	}
+	return data
+}
+
+// register returns the clientDataJSON and attestation object of a "none" attestation.
+func (a *softAuthenticator) register(rpID, origin, challenge string, flags byte) ([]byte, []byte) {
+	attestation := encodeCBOR(cborMap{
+		{"fmt", "none"},
+		{"attStmt", cborMap{}},
+		{"authData", a.authData(rpID, flags|flagAttestedData, true)},
+	})
+	return clientDataJSON("webauthn.create", challenge, origin), attestation
+}
+
+// assert returns the clientDataJSON, authenticator data and signature of an assertion.
+func (a *softAuthenticator) assert(rpID, origin, challenge string, flags byte) ([]byte, []byte, []byte) {
+	a.signCount++
+	cd := clientDataJSON("webauthn.get", challenge, origin)
+	ad := a.authData(rpID, flags, false)
+	clientDataHash := sha256.Sum256(cd)
+	return cd, ad, a.sign(append(bytes.Clone(ad), clientDataHash[:]...))
+}
+
+const uvFlags = flagUserPresent | flagUserVerified
+
+var testConfig = Config{RPID: "fittrme.app", RPName: "FittrMe", Origins: []string{"https://fittrme.app"}}
+
+func registerCredential(t *testing.T, a *softAuthenticator) *Credential {
+	t.Helper()
+	challenge, _ := NewChallenge()
+	cd, att := a.register(testConfig.RPID, "https://fittrme.app", challenge, uvFlags)
+	cred, err := testConfig.VerifyRegistration(challenge, cd, att)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+	return cred
+}
+
+func TestRegistrationAndAssertion(t *testing.T) {
+	for name, newAuthenticator := range map[string]func(*testing.T) *softAuthenticator{
+		"ES256": newES256Authenticator,
+		"EdDSA": newEdDSAAuthenticator,
+	} {
+		t.Run(name, func(t *testing.T) {
+			a := newAuthenticator(t)
+			cred := registerCredential(t, a)
+			if !bytes.Equal(cred.ID, a.credentialID) || !bytes.Equal(cred.PublicKey, a.coseKey) {
+				t.Fatal("registered credential does not match the authenticator's")
+			}
+
+			count := cred.SignCount
+			for i := 0; i < 2; i++ {
+				challenge, _ := NewChallenge()
+				cd, ad, sig := a.assert(testConfig.RPID, "https://fittrme.app", challenge, uvFlags)
+				newCount, err := testConfig.VerifyAssertion(challenge, cred.PublicKey, count, cd, ad, sig)
+				if err != nil {
+					t.Fatalf("VerifyAssertion: %v", err)
+				}
+				if newCount != a.signCount {
+					t.Fatalf("sign count = %d, want %d", newCount, a.signCount)
+				}
+				count = newCount
+			}
+		})
+	}
+}
+
+func TestAssertionCounterRegression(t *testing.T) {
+	a := newES256Authenticator(t)
+	cred := registerCredential(t, a)
+
+	challenge, _ := NewChallenge()
+	cd, ad, sig := a.assert(testConfig.RPID, "https://fittrme.app", challenge, uvFlags)
+	// The stored counter is ahead of the authenticator, as it would be for a clone
+	_, err := testConfig.VerifyAssertion(challenge, cred.PublicKey, a.signCount+5, cd, ad, sig)
+	if !errors.Is(err, ErrCounterRegression) {
+		t.Fatalf("err = %v, want ErrCounterRegression", err)
+	}
+
+	// Authenticators without a counter always report 0, which is allowed
+	a.signCount = 0
+	cd, ad, sig = a.assert(testConfig.RPID, "https://fittrme.app", challenge, uvFlags)
+	binary.BigEndian.PutUint32(ad[33:37], 0)
+	clientDataHash := sha256.Sum256(cd)
+	sig = a.sign(append(bytes.Clone(ad), clientDataHash[:]...))
+	if _, err := testConfig.VerifyAssertion(challenge, cred.PublicKey, 0, cd, ad, sig); err != nil {
+		t.Fatalf("zero counter: %v", err)
+	}
+}
+
+func TestRegistrationRejected(t *testing.T) {
+	challenge, _ := NewChallenge()
+	tests := []struct {
+		name      string
+		rpID      string
+		origin    string
+		challenge string
+		flags     byte
+		want      string
+	}{
+		{"wrong origin", testConfig.RPID, "https://evil.example", challenge, uvFlags, "origin"},
+		{"wrong RP ID", "evil.example", "https://fittrme.app", challenge, uvFlags, "RP ID"},
+		{"wrong challenge", testConfig.RPID, "https://fittrme.app", "other", uvFlags, "challenge"},
+		{"user not verified", testConfig.RPID, "https://fittrme.app", challenge, flagUserPresent, "not verified"},
+		{"user not present", testConfig.RPID, "https://fittrme.app", challenge, flagUserVerified, "not present"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newES256Authenticator(t)
+			cd, att := a.register(tt.rpID, tt.origin, tt.challenge, tt.flags)
+			_, err := testConfig.VerifyRegistration(challenge, cd, att)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want one mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssertionRejected(t *testing.T) {
+	a := newEdDSAAuthenticator(t)
+	cred := registerCredential(t, a)
+	challenge, _ := NewChallenge()
+
+	tests := []struct {
+		name   string
+		rpID   string
+		origin string
+		flags  byte
+		want   string
+	}{
+		{"wrong origin", testConfig.RPID, "https://evil.example", uvFlags, "origin"},
+		{"wrong RP ID", "evil.example", "https://fittrme.app", uvFlags, "RP ID"},
+		{"user not verified", testConfig.RPID, "https://fittrme.app", flagUserPresent, "not verified"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cd, ad, sig := a.assert(tt.rpID, tt.origin, challenge, tt.flags)
+			_, err := testConfig.VerifyAssertion(challenge, cred.PublicKey, 0, cd, ad, sig)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want one mentioning %q", err, tt.want)
+			}
+		})
+	}
+
+	t.Run("tampered signature", func(t *testing.T) {
+		cd, ad, sig := a.assert(testConfig.RPID, "https://fittrme.app", challenge, uvFlags)
+		sig[0] ^= 0xff
+		if _, err := testConfig.VerifyAssertion(challenge, cred.PublicKey, 0, cd, ad, sig); err == nil {
+			t.Fatal("tampered signature accepted")
+		}
+	})
+	t.Run("other credential's key", func(t *testing.T) {
+		other := registerCredential(t, newEdDSAAuthenticator(t))
+		cd, ad, sig := a.assert(testConfig.RPID, "https://fittrme.app", challenge, uvFlags)
+		if _, err := testConfig.VerifyAssertion(challenge, other.PublicKey, 0, cd, ad, sig); err == nil {
+			t.Fatal("signature verified with another credential's key")
+		}
+	})
+}
+
+func TestDecodeCBORMalformed(t *testing.T) {
+	valid := encodeCBOR(cborMap{{"fmt", "none"}, {"authData", []byte{1, 2, 3}}})
+	for i := 0; i < len(valid); i++ {
+		if _, _, err := decodeCBOR(valid[:i]); err == nil {
+			t.Fatalf("truncated to %d bytes: no error", i)
+		}
+	}
+
+	// A byte string claiming more bytes than there are
+	if _, _, err := decodeCBOR([]byte{0x5a, 0xff, 0xff, 0xff, 0xff}); !errors.Is(err, errCBORTruncated) {
+		t.Fatalf("oversized byte string: err = %v", err)
+	}
+	// An array claiming 2^32 items must fail without allocating them
+	if _, _, err := decodeCBOR([]byte{0x9a, 0xff, 0xff, 0xff, 0xff}); err == nil {
+		t.Fatal("oversized array: no error")
+	}
+
+	// Nesting within the limit decodes, one level deeper does not
+	nested := func(depth int) []byte {
+		return append(bytes.Repeat([]byte{0x81}, depth), 0x00) // [[[...[0]...]]]
+	}
+	if _, _, err := decodeCBOR(nested(maxCBORDepth)); err != nil {
+		t.Fatalf("depth %d: %v", maxCBORDepth, err)
+	}
+	if _, _, err := decodeCBOR(nested(maxCBORDepth + 1)); err == nil {
+		t.Fatal("nesting past maxCBORDepth: no error")
+	}
+	if _, _, err := decodeCBOR(nested(100000)); err == nil {
+		t.Fatal("deep nesting: no error")
+	}
+}
+
+func TestVerifyRegistrationMalformed(t *testing.T) {
+	challenge, _ := NewChallenge()
+	cd := clientDataJSON("webauthn.create", challenge, "https://fittrme.app")
+	a := newES256Authenticator(t)
+	_, att := a.register(testConfig.RPID, "https://fittrme.app", challenge, uvFlags)
+
+	for i := 0; i < len(att); i += 7 {
+		if _, err := testConfig.VerifyRegistration(challenge, cd, att[:i]); err == nil {
+			t.Fatalf("attestation object truncated to %d bytes: no error", i)
+		}
+	}
+
+	// A COSE key with a point that is not on the curve
+	a.coseKey = encodeCBOR(cborMap{
+		{coseKeyType, coseKeyTypeEC2}, {coseAlg, AlgES256}, {coseCurve, coseCurveP256},
+		{coseX, bytes.Repeat([]byte{1}, 32)}, {coseY, bytes.Repeat([]byte{2}, 32)},
+	})
+	_, att = a.register(testConfig.RPID, "https://fittrme.app", challenge, uvFlags)
+	if _, err := testConfig.VerifyRegistration(challenge, cd, att); err == nil {
+		t.Fatal("key off the curve accepted")
+	}
+}